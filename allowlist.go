@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/apex/gateway"
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+	"google.golang.org/grpc/peer"
+)
+
+// per channel source ip allowlist of senders.
+
+var errIPNotAllowed = errors.New("source ip not allowed for this channel")
+
+// senderIP returns the source ip of the request.
+// on lambda it's the api gateway source ip, X-Forwarded-For is set by the client and ignored.
+// otherwise X-Forwarded-For is only used when the request comes from TRUSTED_PROXIES.
+func (a *App) senderIP(c *gin.Context) string {
+	if a.cfg.Server.Lambda {
+		if rc, ok := gateway.RequestContext(c.Request.Context()); ok && rc.Identity.SourceIP != "" {
+			return rc.Identity.SourceIP
+		}
+	}
+	if ip := c.ClientIP(); ip != "" {
+		return ip
+	}
+	// unparsable remote address, it doesn't match any allowlist.
+	return c.Request.RemoteAddr
+}
+
+// peerIP returns the source ip of a grpc call.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return addrIP(p.Addr)
+}
+
+// addrIP returns the ip of a network address.
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// parseAllowedIPs validates ips and cidrs, a single ip is turned into a /32 or /128 cidr.
+func parseAllowedIPs(list []string) ([]string, error) {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("wrong ip %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("wrong cidr %s", item)
+		}
+		result = append(result, network.String())
+	}
+	return result, nil
+}
+
+// ipAllowed checks ip against the allowlist, an empty allowlist allows any ip.
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, item := range allowed {
+		_, network, err := net.ParseCIDR(item)
+		if err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSenderIP rejects senders outside of the channel allowlist, rejections are logged
+// and reported to the owner when enabled.
+func (a *App) checkSenderIP(channelInfo *d.ChannelData, tokenName, ip string) error {
+	if ipAllowed(channelInfo.AllowedIPs, ip) {
+		return nil
+	}
+	a.logger.Printf("channel %s: rejected send from %s with token %s", channelInfo.ID, ip, tokenName)
+	if channelInfo.ReportRejectedIP && a.messenger != nil && a.rejectedIPReports.add(channelInfo.ID+"/"+ip, "") {
+		report := fmt.Sprintf("rejected a message to channel %s from %s with token %s, not in the allowed ips.", channelInfo.ID, ip, tokenName)
+		a.messenger.Send(OutgoingMessage{ChatID: channelInfo.Owner, Text: report})
+	}
+	return errIPNotAllowed
+}
+
+func (a *App) botCommandAllowedIPs(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("channel allowed ips: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) < 1 {
+		return buildBotResponse(message, "wrong params, allowed_ips [channel_name] [any|ip_or_cidr,...] [report on|off]")
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	params = params[1:]
+	changed := false
+	if len(params) >= 2 && params[len(params)-2] == "report" {
+		switch params[len(params)-1] {
+		case "on":
+			channelInfo.ReportRejectedIP = true
+		case "off":
+			channelInfo.ReportRejectedIP = false
+		default:
+			return buildBotResponse(message, "wrong params, report on|off")
+		}
+		params = params[:len(params)-2]
+		changed = true
+	}
+	if len(params) > 0 {
+		if len(params) == 1 && params[0] == "any" {
+			channelInfo.AllowedIPs = nil
+		} else {
+			allowed, err := parseAllowedIPs(splitTags(strings.Join(params, ",")))
+			if err != nil {
+				return buildBotResponse(message, err.Error())
+			}
+			channelInfo.AllowedIPs = allowed
+		}
+		changed = true
+	}
+	if changed {
+		if err := a.store.Update(channelInfo); err != nil {
+			a.logger.Println("update channel info failed ", err)
+			return buildBotResponse(message, "update failed")
+		}
+	}
+
+	allowed := "any"
+	if len(channelInfo.AllowedIPs) > 0 {
+		allowed = strings.Join(channelInfo.AllowedIPs, ", ")
+	}
+	report := "off"
+	if channelInfo.ReportRejectedIP {
+		report = "on"
+	}
+	return buildBotResponse(message, fmt.Sprintf("allowed ips: %s\nreport rejected: %s", allowed, report))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseAllowedIPs(t *testing.T) {
+	allowed, err := parseAllowedIPs([]string{"1.2.3.4", "10.1.2.3/8", "2001:db8::1"})
+	if err != nil || len(allowed) != 3 || allowed[0] != "1.2.3.4/32" || allowed[1] != "10.0.0.0/8" || allowed[2] != "2001:db8::1/128" {
+		log.Println(allowed, err)
+		t.Fail()
+	}
+	for _, item := range []string{"1.2.3", "10.0.0.0/33", "example.com"} {
+		if _, err := parseAllowedIPs([]string{item}); err == nil {
+			log.Printf("[%s] should be rejected", item)
+			t.Fail()
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowed := []string{"1.2.3.4/32", "10.0.0.0/8"}
+	list := map[string]bool{
+		"1.2.3.4":    true,
+		"10.20.30.4": true,
+		"1.2.3.5":    false,
+		"":           false,
+		"[::1]:80":   false,
+	}
+	for ip, isOK := range list {
+		if ipAllowed(allowed, ip) != isOK {
+			log.Printf("[%s] should %t", ip, isOK)
+			t.Fail()
+		}
+	}
+	if !ipAllowed(nil, "1.2.3.5") {
+		log.Println("empty allowlist should allow any ip")
+		t.Fail()
+	}
+}
+
+func TestSenderIP(t *testing.T) {
+	for _, item := range []struct {
+		trusted    []string
+		remoteAddr string
+		want       string
+	}{
+		{nil, "10.0.0.1:1234", "10.0.0.1"},
+		{[]string{"10.0.0.0/8"}, "10.0.0.1:1234", "1.2.3.4"},
+		{[]string{"10.0.0.0/8"}, "5.6.7.8:1234", "5.6.7.8"},
+	} {
+		router := gin.New()
+		router.SetTrustedProxies(item.trusted)
+		a := testApp(&config{})
+		var got string
+		router.GET("/", func(c *gin.Context) {
+			got = a.senderIP(c)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = item.remoteAddr
+		// the left most address is set by the client.
+		req.Header.Set("X-Forwarded-For", "9.9.9.9, 1.2.3.4")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if got != item.want {
+			log.Printf("trusted %v remote %s: should be %s, got %s", item.trusted, item.remoteAddr, item.want, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/gateway"
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// App is a running bot with its config, store and telegram client.
+// handlers are methods of App instead of using package globals, so several apps can run in one process.
+type App struct {
+	// bot name, empty for the main bot.
+	name  string
+	cfg   *config
+	store *d.Channel
+	bot   *tgbotapi.BotAPI
+	// telegram username of the bot, for t.me links.
+	username string
+	// sends the messages of the bot, handlers don't use bot directly.
+	messenger Messenger
+	logger    *log.Logger
+
+	// bot commands, by default botCommands.
+	commands []botCommand
+
+	// secret_token of setWebhook, sent back in X-Telegram-Bot-Api-Secret-Token.
+	webhookSecret string
+	// applies to channels without their own limit.
+	channelRateLimit d.RateLimit
+	// the setWebhook error for /sysinfo.
+	webhookRegisterError string
+	// the getWebhookInfo status of /sysinfo, as /sysinfo is public it doesn't call telegram on every request.
+	webhookInfo *resultCache
+
+	// Idempotency-Key results of /send seen by this instance, all instances share the idempotency collection.
+	sendResults *resultCache
+	// nonces used on this instance, all instances share the nonce collection.
+	// nonces are kept longer than the allowed skew so a replay is always caught.
+	signatureNonces *resultCache
+	// the owner is told about a rejected ip once an hour.
+	rejectedIPReports *resultCache
+	// avoids the db lookup for redeliveries to the same instance.
+	processedUpdates *resultCache
+}
+
+// newApp creates the app with the validated config, without connecting to anything.
+func newApp(cfg *config, logger *log.Logger) *App {
+	a := &App{
+		cfg:               cfg,
+		commands:          botCommands(),
+		logger:            logger,
+		webhookSecret:     cfg.Webhook.Secret,
+		sendResults:       newResultCache(sendResultTTL),
+		signatureNonces:   newResultCache(2 * signatureMaxSkew),
+		rejectedIPReports: newResultCache(time.Hour),
+		processedUpdates:  newResultCache(10 * time.Minute),
+		webhookInfo:       newResultCache(time.Minute),
+	}
+	if a.webhookSecret == "" {
+		a.webhookSecret = defaultWebhookSecret(cfg.Telegram.Token)
+	}
+	if cfg.Server.ChannelRateLimit != "" {
+		a.channelRateLimit, _ = parseRateLimit(strings.Fields(cfg.Server.ChannelRateLimit))
+	}
+	return a
+}
+
+// NewApp creates the app of the named bot and connects to the Bot API.
+func NewApp(name string, cfg *config, store *d.Channel, logger *log.Logger) (*App, error) {
+	a := newApp(cfg, logger)
+	a.name = name
+	a.store = store
+
+	var err error
+	a.bot, err = newBotAPI(cfg.Telegram.Token, cfg.Telegram.APIURL)
+	if err != nil {
+		return nil, err
+	}
+	a.bot.Debug = cfg.Server.Debug
+	a.username = a.bot.Self.UserName
+	a.messenger = newTelegramMessenger(a.bot)
+	a.logger.Printf("Authorized on account %s", a.username)
+	return a, nil
+}
+
+// isAdmin checks the chat is an admin of the bot.
+func (a *App) isAdmin(chatID int64) bool {
+	if chatID == 0 {
+		return false
+	}
+	if chatID == a.cfg.Telegram.AdminChatID {
+		return true
+	}
+	for _, admin := range a.cfg.Telegram.Admins {
+		if chatID == admin {
+			return true
+		}
+	}
+	return false
+}
+
+// registerRoutes adds the update source of the bot and its send api.
+// the webhook is on the root router, the send api of extra bots is under /b/<name>.
+func (a *App) registerRoutes(router *gin.Engine) error {
+	if a.cfg.Telegram.Mode == "polling" {
+		// telegram refuses getUpdates while a webhook is set.
+		if _, err := a.bot.RemoveWebhook(); err != nil {
+			return err
+		}
+	} else {
+		a.registerWebhook(router)
+	}
+	if a.name == "" {
+		a.registerSendRoutes(router)
+	} else {
+		a.registerSendRoutes(router.Group("/b/" + a.name))
+	}
+	return nil
+}
+
+// Server runs the main bot and the extra bots of the config on one http server, sharing the store.
+// smtp, syslog, grpc and mqtt deliver to the channels of the main bot.
+type Server struct {
+	cfg   *config
+	store *d.Channel
+	// the main bot is the first.
+	apps []*App
+}
+
+// NewServer connects to the store and creates the apps of all bots, cfg should be validated.
+func NewServer(cfg *config) (*Server, error) {
+	firebaseToken, err := base64.StdEncoding.DecodeString(cfg.Firebase.Token)
+	if err != nil {
+		return nil, err
+	}
+	store, err := d.NewChannel(context.Background(), firebaseToken)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{cfg: cfg, store: store}
+
+	app, err := NewApp("", cfg, store, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.apps = append(s.apps, app)
+	for _, bot := range cfg.Bots {
+		logger := log.New(os.Stderr, "["+bot.Name+"] ", log.LstdFlags|log.Lmsgprefix)
+		app, err := NewApp(bot.Name, cfg.forBot(bot), store.ForBot(bot.Name), logger)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("bot %s: %w", bot.Name, err)
+		}
+		s.apps = append(s.apps, app)
+	}
+	return s, nil
+}
+
+// Close closes the store.
+func (s *Server) Close() {
+	s.store.Close()
+}
+
+// Run serves http and the configured ingresses until one of them fails or ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	router, err := s.createRouter()
+	if err != nil {
+		return err
+	}
+	for _, a := range s.apps {
+		if err := a.registerRoutes(router); err != nil {
+			return err
+		}
+		if a.cfg.Telegram.Mode == "polling" {
+			go a.pollUpdates(ctx, botUpdates(a.bot), a.processUpdate)
+		}
+		go a.syncCommands()
+	}
+
+	main := s.apps[0]
+	errs := make(chan error, 5)
+	if s.cfg.SMTP.Listen != "" {
+		go func() {
+			errs <- main.serveSMTP(s.cfg.SMTP.Listen, s.cfg.SMTP.Domain)
+		}()
+	}
+	if s.cfg.Syslog.Listen != "" {
+		go func() {
+			errs <- main.serveSyslog(s.cfg.Syslog.Listen, s.cfg.syslogRules)
+		}()
+	}
+	if s.cfg.GRPC.Listen != "" {
+		go func() {
+			errs <- main.serveGRPC(s.cfg.GRPC)
+		}()
+	}
+	if s.cfg.MQTT.Broker != "" {
+		client, err := startMQTTBridge(s.cfg.MQTT.Broker, s.cfg.MQTT.ClientID, s.cfg.MQTT.Username, s.cfg.MQTT.Password, main.newChannelMQTTBridge(s.cfg.mqttMappings))
+		if err != nil {
+			return errors.New("mqtt connect failed: " + err.Error())
+		}
+		defer client.Disconnect(250)
+	}
+
+	listenAddr := s.cfg.listenAddr()
+	log.Printf("listen Addr: %s, %d bots\n", listenAddr, len(s.apps))
+	if s.cfg.Server.Lambda {
+		go func() {
+			errs <- gateway.ListenAndServe(listenAddr, router)
+		}()
+	} else {
+		server := &http.Server{Addr: listenAddr, Handler: router}
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+		defer server.Close()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"testing"
+)
+
+// testApp is an app without store and bot, for handlers which don't use them.
+func testApp(cfg *config) *App {
+	return newApp(cfg, log.New(os.Stderr, "", log.LstdFlags))
+}
+
+func TestNewServerError(t *testing.T) {
+	cfg := &config{}
+	cfg.Telegram.Token = "123:abc"
+	cfg.Firebase.Token = base64.StdEncoding.EncodeToString([]byte(`{"type": "service_account"}`))
+	server, err := NewServer(cfg)
+	if err == nil || server != nil {
+		log.Println("wrong credentials should fail: ", err)
+		t.Fail()
+	}
+}
+
+func TestAppsAreIndependent(t *testing.T) {
+	a := testApp(&config{})
+	b := testApp(&config{Telegram: telegramConfig{Token: "123:abd"}})
+	if a.webhookSecret == b.webhookSecret {
+		log.Println("apps of different bots should have different webhook secrets")
+		t.Fail()
+	}
+	a.sendResults.set("ch/key", "ok")
+	if _, ok := b.sendResults.get("ch/key"); ok {
+		log.Println("apps should not share caches")
+		t.Fail()
+	}
+}
+
+func TestAppIsAdmin(t *testing.T) {
+	cfg := &config{Telegram: telegramConfig{AdminChatID: 1, Admins: []int64{2}}}
+	a := testApp(cfg)
+	if !a.isAdmin(1) || !a.isAdmin(2) || a.isAdmin(3) || a.isAdmin(0) {
+		t.Fail()
+	}
+	staging := testApp(cfg.forBot(botConfig{Name: "staging", Admins: []int64{3}}))
+	if staging.isAdmin(1) || !staging.isAdmin(3) {
+		log.Println("bots should have their own admins")
+		t.Fail()
+	}
+}
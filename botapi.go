@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// the v4 api has a const endpoint, requests to api.telegram.org are sent to the configured
+// Bot API server instead, e.g. a self-hosted telegram-bot-api or a test stub.
+
+const telegramAPIHost = "api.telegram.org"
+
+// apiEndpointTransport rewrites the scheme, host and path prefix of requests to api.telegram.org.
+type apiEndpointTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *apiEndpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != telegramAPIHost {
+		return t.next.RoundTrip(req)
+	}
+	u := *req.URL
+	u.Scheme = t.base.Scheme
+	u.Host = t.base.Host
+	u.Path = strings.TrimRight(t.base.Path, "/") + u.Path
+	req = req.Clone(req.Context())
+	req.URL = &u
+	req.Host = ""
+	return t.next.RoundTrip(req)
+}
+
+// newBotAPI creates the bot, apiURL is the Bot API server, empty for api.telegram.org.
+func newBotAPI(token, apiURL string) (*tgbotapi.BotAPI, error) {
+	if apiURL == "" {
+		return tgbotapi.NewBotAPI(token)
+	}
+	base, err := url.Parse(apiURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.New("bot api url should be like http://127.0.0.1:8081")
+	}
+	client := &http.Client{Transport: &apiEndpointTransport{base: base, next: http.DefaultTransport}}
+	return tgbotapi.NewBotAPIWithClient(token, client)
+}
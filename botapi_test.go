@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestNewBotAPIURL(t *testing.T) {
+	paths := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/bot123:abc/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"test","username":"test_bot"}}`))
+		case "/api/bot123:abc/sendMessage":
+			w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":42},"text":"hello"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	bot, err := newBotAPI("123:abc", server.URL+"/api/")
+	if err != nil || bot.Self.UserName != "test_bot" {
+		log.Println("create bot failed: ", err)
+		t.FailNow()
+	}
+	if _, err := bot.Send(tgbotapi.NewMessage(42, "hello")); err != nil {
+		log.Println("send failed: ", err)
+		t.Fail()
+	}
+	if len(paths) != 2 {
+		log.Println("paths: ", paths)
+		t.Fail()
+	}
+
+	for _, apiURL := range []string{"127.0.0.1:8081", "ftp://example.com", "http://"} {
+		if _, err := newBotAPI("123:abc", apiURL); err == nil {
+			log.Printf("[%s] should be rejected", apiURL)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// resultCache keeps string results for a while, it's local to the process.
+type resultCache struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	items map[string]resultCacheItem
+}
+
+type resultCacheItem struct {
+	value   string
+	expires time.Time
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{ttl: ttl, items: make(map[string]resultCacheItem)}
+}
+
+func (c *resultCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expires) {
+		return "", false
+	}
+	return item.value, true
+}
+
+func (c *resultCache) set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(key, value)
+}
+
+// add sets the value only if the key is missing, it returns false if the key exists.
+func (c *resultCache) add(key, value string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.items[key]; ok && !time.Now().After(item.expires) {
+		return false
+	}
+	c.setLocked(key, value)
+	return true
+}
+
+func (c *resultCache) setLocked(key, value string) {
+	now := time.Now()
+	for k, item := range c.items {
+		if now.After(item.expires) {
+			delete(c.items, k)
+		}
+	}
+	c.items[key] = resultCacheItem{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,298 @@
+// Package client sends messages to a telegram-messager channel.
+//
+//	c := client.New("https://bot.example.com", "builds", "<token>")
+//	_, err := c.Send(ctx, &client.Message{Text: "build *ok*", Format: client.FormatMarkdown})
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the parse mode of the message text.
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+var (
+	// ErrUnauthorized is returned when the channel not exists or the token not match.
+	ErrUnauthorized = errors.New("channel not exist or token not match")
+	// ErrBadRequest is returned when the server rejected the request.
+	ErrBadRequest = errors.New("bad request")
+	// ErrServer is returned when the server failed, the request is retried.
+	ErrServer = errors.New("server error")
+)
+
+// Error is the error returned by the server.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the wait asked by the server when rate limited.
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram-messager: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap allows errors.Is(err, ErrUnauthorized) and the other kinds.
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// Attachment is a file sent after the message.
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Message is the message sent to the channel.
+type Message struct {
+	Text        string
+	Format      Format
+	Silent      bool
+	Attachments []Attachment
+	// IdempotencyKey makes retries safe, the server returns the first result for the same key.
+	// a random key is used when empty.
+	IdempotencyKey string
+}
+
+// Result is the result of a sent message.
+type Result struct {
+	// Receivers is the number of users the message was sent to.
+	Receivers int
+	// Replayed is true when the result is from an earlier request with the same idempotency key.
+	Replayed bool
+}
+
+// Client sends messages to one channel.
+type Client struct {
+	baseURL    string
+	channel    string
+	token      string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	secret     string
+}
+
+// Option configures the Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http client, the default is a client with 30s timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets the retry count and the initial backoff, which is doubled for every retry.
+// the default is 3 retries starting with 1s.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithSigningSecret signs every request with the channel signing secret,
+// required when signing is enabled for the channel.
+func WithSigningSecret(secret string) Option {
+	return func(c *Client) {
+		c.secret = secret
+	}
+}
+
+// New creates a client of the channel, baseURL is the server url, e.g. https://bot.example.com
+func New(baseURL, channel, token string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		channel:    channel,
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    3,
+		backoff:    time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SendText sends a plain text message.
+func (c *Client) SendText(ctx context.Context, text string) (*Result, error) {
+	return c.Send(ctx, &Message{Text: text})
+}
+
+// Send sends the message, server errors and network errors are retried.
+// when rate limited the retry waits at least the Retry-After of the server.
+func (c *Client) Send(ctx context.Context, msg *Message) (*Result, error) {
+	if msg.Text == "" && len(msg.Attachments) == 0 {
+		return nil, fmt.Errorf("%w: text or attachments required", ErrBadRequest)
+	}
+	idempotencyKey := msg.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = randomKey()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		result, err := c.send(ctx, msg, idempotencyKey)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return result, err
+		}
+		wait := backoff
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > wait {
+			wait = e.RetryAfter
+		}
+		// a daily quota can't be waited out, fail now instead of at the deadline.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, msg *Message, idempotencyKey string) (*Result, error) {
+	body, contentType, err := encodeBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/send", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.secret != "" {
+		// a new nonce for every attempt, the server rejects a reused nonce.
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := randomKey()
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature-Nonce", nonce)
+		req.Header.Set("X-Signature", sign(c.secret, req.Method, req.URL.Path, timestamp, nonce, body))
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-ChannelName", c.channel)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if msg.Format != "" {
+		req.Header.Set("X-Format", string(msg.Format))
+	}
+	if msg.Silent {
+		req.Header.Set("X-Silent", "true")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(respBody))
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp.StatusCode, text)
+		if seconds, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil && seconds > 0 {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, err
+	}
+
+	result := &Result{Replayed: resp.Header.Get("Idempotent-Replayed") == "true"}
+	if _, err := fmt.Sscanf(text, "ok, send to %d user", &result.Receivers); err != nil {
+		return nil, fmt.Errorf("unexpected response: %s", text)
+	}
+	return result, nil
+}
+
+// encodeBody encodes the message as multipart, the server decodes text bodies which are valid base64
+// so "done" would arrive as binary, the message field is sent as it is.
+func encodeBody(msg *Message) ([]byte, string, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	if msg.Text != "" {
+		if err := w.WriteField("message", msg.Text); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, a := range msg.Attachments {
+		part, err := w.CreateFormFile("file", a.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(a.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return b.Bytes(), w.FormDataContentType(), nil
+}
+
+// sign returns the hex hmac-sha256 of method, path, timestamp, nonce and body.
+func sign(secret, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func responseError(statusCode int, message string) *Error {
+	e := &Error{StatusCode: statusCode, Message: message, kind: ErrBadRequest}
+	switch {
+	// 409 is a retry while the first request with the idempotency key is still delivering.
+	case statusCode >= 500 || statusCode == http.StatusTooManyRequests || statusCode == http.StatusConflict:
+		e.kind = ErrServer
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+		strings.Contains(message, ErrUnauthorized.Error()):
+		e.kind = ErrUnauthorized
+	}
+	return e
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.kind == ErrServer
+	}
+	var netErr *url.Error
+	return errors.As(err, &netErr)
+}
+
+func randomKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/send" || r.Header.Get("X-ChannelName") != "builds" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Idempotency-Key") == "" || r.Header.Get("X-Format") != "markdown" || r.Header.Get("X-Silent") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("message") != "*ok*" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok, send to 3 user"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/", "builds", "token", WithRetries(2, time.Millisecond))
+	result, err := c.Send(context.Background(), &Message{Text: "*ok*", Format: FormatMarkdown, Silent: true})
+	if err != nil || result.Receivers != 3 || calls != 2 {
+		log.Println(result, err, calls)
+		t.Fail()
+	}
+}
+
+func TestSign(t *testing.T) {
+	s := sign("secret", "POST", "/send", "1700000000", "abc", []byte("hello"))
+	if s != "5ebd3a799a96691623f1d972476bbe803bb3b445a61d019d0ba66ee784d7a0da" {
+		log.Println("wrong signature: ", s)
+		t.Fail()
+	}
+}
+
+func TestSendSigned(t *testing.T) {
+	nonces := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, nonce := r.Header.Get("X-Signature-Timestamp"), r.Header.Get("X-Signature-Nonce")
+		if nonces[nonce] || r.Header.Get("X-Signature") != sign("secret", r.Method, r.URL.Path, timestamp, nonce, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		nonces[nonce] = true
+		if len(nonces) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok, send to 1 user"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/prefix", "builds", "token", WithSigningSecret("secret"), WithRetries(1, time.Millisecond))
+	if _, err := c.SendText(context.Background(), "hello"); err != nil {
+		log.Println(err)
+		t.Fail()
+	}
+}
+
+func TestSendAttachments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("message") != "report" || len(r.MultipartForm.File["file"]) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.Write([]byte("ok, send to 1 user"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "builds", "token")
+	result, err := c.Send(context.Background(), &Message{
+		Text:           "report",
+		Attachments:    []Attachment{{Name: "a.txt", Data: []byte("a")}, {Name: "b.txt", Data: []byte("b")}},
+		IdempotencyKey: "report-1",
+	})
+	if err != nil || result.Receivers != 1 || !result.Replayed {
+		log.Println(result, err)
+		t.Fail()
+	}
+}
+
+func TestSendTextBase64(t *testing.T) {
+	received := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a text body would be base64 decoded by the server.
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = r.FormValue("message")
+		w.Write([]byte("ok, send to 1 user"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "builds", "token")
+	if _, err := c.SendText(context.Background(), "done"); err != nil || received != "done" {
+		log.Println(received, err)
+		t.Fail()
+	}
+}
+
+func TestSendInProgress(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("a request with the same Idempotency-Key is in progress"))
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.Write([]byte("ok, send to 1 user"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "builds", "token", WithRetries(1, time.Millisecond))
+	result, err := c.SendText(context.Background(), "hello")
+	if err != nil || !result.Replayed || calls != 2 {
+		log.Println(result, err, calls)
+		t.Fail()
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("channel not exist or token not match"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "builds", "wrong", WithRetries(3, time.Millisecond))
+	_, err := c.SendText(context.Background(), "hello")
+	var e *Error
+	if !errors.Is(err, ErrUnauthorized) || !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest || calls != 1 {
+		log.Println(err, calls)
+		t.Fail()
+	}
+
+	if _, err := c.SendText(context.Background(), ""); !errors.Is(err, ErrBadRequest) {
+		t.Fail()
+	}
+}
+
+func TestSendRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate limit exceeded, retry after 3600 seconds"))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c := New(server.URL, "builds", "token", WithRetries(3, time.Millisecond))
+	_, err := c.SendText(ctx, "hello")
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusTooManyRequests || e.RetryAfter != time.Hour {
+		log.Println(err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,186 @@
+// tgmsg sends a message to a telegram-messager channel.
+//
+//	tgmsg -c builds "deploy done"
+//	make test 2>&1 | tgmsg -c builds -tee
+//	tgmsg -c builds -f report.pdf "daily report"
+//
+// The server and channel tokens are read from the config file (~/.config/tgmsg/config.json)
+// and the TGMSG_SERVER, TGMSG_CHANNEL and TGMSG_TOKEN env vars, flags have the highest priority.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hitian/telegram-messager/client"
+)
+
+const (
+	// telegram message length limit is 4096, longer text is cut and sent as file.
+	maxTextLength = 4000
+	exitFailed    = 1
+	exitUsage     = 2
+)
+
+type config struct {
+	Server  string `json:"server"`
+	Channel string `json:"channel"`
+	// channel name => token
+	Tokens map[string]string `json:"tokens"`
+}
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tgmsg", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		files      fileList
+		configPath = flags.String("config", defaultConfigPath(), "config file path")
+		server     = flags.String("s", "", "server url")
+		channel    = flags.String("c", "", "channel name")
+		token      = flags.String("t", "", "channel token")
+		format     = flags.String("format", "", "text, markdown or html")
+		silent     = flags.Bool("silent", false, "send without notification")
+		tee        = flags.Bool("tee", false, "copy stdin to stdout")
+		timeout    = flags.Duration("timeout", time.Minute, "timeout including retries")
+	)
+	flags.Var(&files, "f", "attach file, can be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: tgmsg [flags] [message]\n\nthe message is read from stdin when no message argument.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "tgmsg: load config failed:", err)
+		return exitUsage
+	}
+	cfg.override(os.Getenv("TGMSG_SERVER"), os.Getenv("TGMSG_CHANNEL"), os.Getenv("TGMSG_TOKEN"))
+	cfg.override(*server, *channel, *token)
+	channelToken := cfg.Tokens[cfg.Channel]
+	if cfg.Server == "" || cfg.Channel == "" || channelToken == "" {
+		fmt.Fprintln(stderr, "tgmsg: server, channel and token required")
+		return exitUsage
+	}
+
+	text := strings.Join(flags.Args(), " ")
+	if text == "" {
+		var r io.Reader = stdin
+		if *tee {
+			r = io.TeeReader(stdin, stdout)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			fmt.Fprintln(stderr, "tgmsg: read stdin failed:", err)
+			return exitFailed
+		}
+		text = string(data)
+	}
+
+	msg := &client.Message{Format: client.Format(*format), Silent: *silent}
+	msg.Text, msg.Attachments = splitLongText(text)
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "tgmsg: read file failed:", err)
+			return exitFailed
+		}
+		msg.Attachments = append(msg.Attachments, client.Attachment{Name: filepath.Base(path), Data: data})
+	}
+	if strings.TrimSpace(msg.Text) == "" && len(msg.Attachments) == 0 {
+		fmt.Fprintln(stderr, "tgmsg: empty message")
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(cfg.Server, cfg.Channel, channelToken)
+	if _, err := c.Send(ctx, msg); err != nil {
+		fmt.Fprintln(stderr, "tgmsg: send failed:", err)
+		return exitFailed
+	}
+	return 0
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("TGMSG_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tgmsg", "config.json")
+}
+
+// loadConfig reads the config file, a missing file is an empty config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Tokens: make(map[string]string)}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = make(map[string]string)
+	}
+	return cfg, nil
+}
+
+// override sets the non-empty values, the token belongs to the resulting channel.
+func (c *config) override(server, channel, token string) {
+	if server != "" {
+		c.Server = server
+	}
+	if channel != "" {
+		c.Channel = channel
+	}
+	if token != "" {
+		c.Tokens[c.Channel] = token
+	}
+}
+
+// splitLongText keeps the head and tail of a long text and attaches the full text as output.txt.
+func splitLongText(text string) (string, []client.Attachment) {
+	runes := []rune(text)
+	if len(runes) <= maxTextLength {
+		return text, nil
+	}
+	half := maxTextLength / 2
+	short := string(runes[:half]) + "\n...\n" + string(runes[len(runes)-half:])
+	return short, []client.Attachment{{Name: "output.txt", Data: []byte(text)}}
+}
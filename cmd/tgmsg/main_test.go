@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	received := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ChannelName") != "builds" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("channel not exist or token not match"))
+			return
+		}
+		received = r.FormValue("message")
+		w.Write([]byte("ok, send to 2 user"))
+	}))
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	config := `{"server": "` + server.URL + `", "channel": "builds", "tokens": {"builds": "secret"}}`
+	os.WriteFile(configPath, []byte(config), 0600)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", configPath, "-tee"}, strings.NewReader("test ok\n"), &stdout, &stderr)
+	if code != 0 || received != "test ok\n" || stdout.String() != "test ok\n" {
+		log.Println(code, received, stdout.String(), stderr.String())
+		t.Fail()
+	}
+
+	// valid base64, it should not be decoded by the server.
+	code = run([]string{"-config", configPath}, strings.NewReader("PASS\n"), &stdout, &stderr)
+	if code != 0 || received != "PASS\n" {
+		log.Println(code, received, stderr.String())
+		t.Fail()
+	}
+
+	code = run([]string{"-config", configPath, "deploy", "done"}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 || received != "deploy done" {
+		log.Println(code, received, stderr.String())
+		t.Fail()
+	}
+
+	stderr.Reset()
+	code = run([]string{"-config", configPath, "-t", "wrong", "hello"}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitFailed || !strings.Contains(stderr.String(), "token not match") {
+		log.Println(code, stderr.String())
+		t.Fail()
+	}
+
+	code = run([]string{"-config", filepath.Join(t.TempDir(), "none.json"), "hello"}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitUsage {
+		t.Fail()
+	}
+}
+
+func TestSplitLongText(t *testing.T) {
+	text, files := splitLongText("short")
+	if text != "short" || files != nil {
+		t.Fail()
+	}
+	long := strings.Repeat("中", maxTextLength+1)
+	text, files = splitLongText(long)
+	if len([]rune(text)) > maxTextLength+5 || len(files) != 1 || string(files[0].Data) != long {
+		t.Fail()
+	}
+}
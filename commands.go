@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bot commands are declared once, the dispatcher, /help and the client command menu are built from the list.
+
+// commandRole is who can run a command.
+type commandRole int
+
+const (
+	// anyone.
+	roleUser commandRole = iota
+	// channel owners, the handler checks the channel is owned by the chat.
+	roleOwner
+	// admins of the bot, checked before the handler.
+	roleAdmin
+)
+
+type botCommand struct {
+	name string
+	// commands without description are not listed in /help and the menu.
+	description string
+	// arguments shown in /help, empty for none.
+	usage  string
+	role   commandRole
+	handle func(a *App, message *tgbotapi.Message, args string) *OutgoingMessage
+}
+
+// botCommands returns the commands of the bot in the order of the menu.
+func botCommands() []botCommand {
+	return []botCommand{
+		{"start", "", "", roleUser, (*App).botCommandStart},
+		{"follow", "Follow a channel", "[channel_name]", roleUser, (*App).botCommandFollow},
+		{"unfollow", "Unfollow a channel", "[channel_name]", roleUser, (*App).botCommandUnfollow},
+		{"list", "List my channels", "", roleUser, (*App).botCommandList},
+		{"myid", "Show my chat ID", "", roleUser, (*App).botCommandMyID},
+		{"help", "Show the commands", "", roleUser, (*App).botCommandHelp},
+		{"token", "Show a legacy plaintext token once, /token_rotate resets it", "[channel_name]", roleOwner, (*App).botCommandToken},
+		{"token_add", "Add a named token", "[channel_name] [token_name] [send,subscribers] [expiry: 30d, 12h or 2006-01-02]", roleOwner, (*App).botCommandTokenAdd},
+		{"token_rotate", "Rotate a named token", "[channel_name] [token_name]", roleOwner, (*App).botCommandTokenRotate},
+		{"token_list", "List channel tokens", "[channel_name]", roleOwner, (*App).botCommandTokenList},
+		{"token_revoke", "Revoke a named token", "[channel_name] [token_name]", roleOwner, (*App).botCommandTokenRevoke},
+		{"channel_users", "List channel followers", "[channel_name]", roleOwner, (*App).botCommandChannelUsers},
+		{"channel_kick", "Remove a follower", "[channel_name] [user_id]", roleOwner, (*App).botCommandChannelKick},
+		{"url_token", "Allow the token in send urls", "[channel_name] [on|off]", roleOwner, (*App).botCommandURLToken},
+		{"signing", "Require signed send requests", "[channel_name] [on|off]", roleOwner, (*App).botCommandSigning},
+		{"allowed_ips", "Limit the sender ips", "[channel_name] [any|ip_or_cidr,...] [report on|off]", roleOwner, (*App).botCommandAllowedIPs},
+		{"rate_limit", "Limit messages per minute and day", "[channel_name] [token=name] [minute=N burst=N day=N|off]", roleOwner, (*App).botCommandRateLimit},
+		{"invite", "Share a follow link and qr code", "[channel_name] [once] [expiry: 30d, 12h or 2006-01-02]", roleOwner, (*App).botCommandInvite},
+		{"new", "Add a new channel", "[channel_name]", roleAdmin, (*App).botCommandNewChannel},
+		{"migrate_tokens", "Hash the plaintext tokens", "", roleAdmin, (*App).botCommandMigrateTokens},
+	}
+}
+
+// findCommand returns the command by name.
+func (a *App) findCommand(name string) (botCommand, bool) {
+	for _, command := range a.commands {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return botCommand{}, false
+}
+
+// commandsFor returns the listed commands up to the role.
+func (a *App) commandsFor(role commandRole) []botCommand {
+	result := make([]botCommand, 0, len(a.commands))
+	for _, command := range a.commands {
+		if command.role <= role && command.description != "" {
+			result = append(result, command)
+		}
+	}
+	return result
+}
+
+// roleOf returns the highest role of the chat.
+func (a *App) roleOf(chatID int64) commandRole {
+	if a.isAdmin(chatID) {
+		return roleAdmin
+	}
+	owned, err := a.store.GetOwned(chatID)
+	if err != nil {
+		// the handlers check the owner, showing more commands is harmless.
+		a.logger.Println("fetch owned channels failed: ", err)
+		return roleOwner
+	}
+	if len(owned) > 0 {
+		return roleOwner
+	}
+	return roleUser
+}
+
+func (a *App) botCommandMyID(message *tgbotapi.Message, args string) *OutgoingMessage {
+	return buildBotResponse(message, fmt.Sprintf("%d", message.Chat.ID))
+}
+
+func (a *App) botCommandHelp(message *tgbotapi.Message, args string) *OutgoingMessage {
+	return buildBotResponse(message, a.helpText(a.roleOf(message.Chat.ID)))
+}
+
+// helpText lists the commands of the role with their arguments.
+func (a *App) helpText(role commandRole) string {
+	lines := make([]string, 0, len(a.commands))
+	for _, command := range a.commandsFor(role) {
+		line := "/" + command.name
+		if command.usage != "" {
+			line += " " + command.usage
+		}
+		lines = append(lines, line+" - "+command.description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// syncCommands sets the command menu of the bot, so it doesn't have to be pasted into @BotFather.
+// everyone gets the user commands, group admins the owner commands, as a group can own a channel,
+// and the admins of the bot all commands.
+func (a *App) syncCommands() {
+	menu := func(role commandRole) []MenuCommand {
+		result := make([]MenuCommand, 0, len(a.commands))
+		for _, command := range a.commandsFor(role) {
+			result = append(result, MenuCommand{Command: command.name, Description: command.description})
+		}
+		return result
+	}
+	scopes := []CommandScope{{Type: "default"}, {Type: "all_chat_administrators"}}
+	roles := []commandRole{roleUser, roleOwner}
+	admins := append([]int64{a.cfg.Telegram.AdminChatID}, a.cfg.Telegram.Admins...)
+	for _, admin := range admins {
+		if admin != 0 {
+			scopes = append(scopes, CommandScope{Type: "chat", ChatID: admin})
+			roles = append(roles, roleAdmin)
+		}
+	}
+	for i, scope := range scopes {
+		if err := a.messenger.SetCommands(scope, menu(roles[i])); err != nil {
+			a.logger.Printf("set %s commands failed: %s", scope.Type, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// commandMessage is a command message in the private chat of chatID.
+func commandMessage(chatID int64, text string) *tgbotapi.Message {
+	command := strings.Fields(text)[0]
+	return &tgbotapi.Message{
+		MessageID: 1,
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Text:      text,
+		Entities:  &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+func TestBotCommands(t *testing.T) {
+	// the limits of setMyCommands.
+	namePattern := regexp.MustCompile("^[a-z0-9_]{1,32}$")
+	names := make(map[string]bool)
+	for _, command := range botCommands() {
+		if !namePattern.MatchString(command.name) || names[command.name] || len(command.description) > 256 || command.handle == nil {
+			log.Printf("wrong command %s", command.name)
+			t.Fail()
+		}
+		names[command.name] = true
+	}
+}
+
+func TestBotCommandDispatch(t *testing.T) {
+	messenger := &fakeMessenger{}
+	a := testApp(&config{Telegram: telegramConfig{AdminChatID: 1}})
+	a.messenger = messenger
+
+	a.botMessageProcess(commandMessage(2, "/myid"))
+	a.botMessageProcess(commandMessage(2, "/migrate_tokens"))
+	a.botMessageProcess(commandMessage(2, "/unknown"))
+	a.botMessageProcess(commandMessage(1, "/help"))
+	a.botMessageProcess(commandMessage(1, "/start"))
+	if len(messenger.messages) != 5 {
+		log.Printf("every command should be answered %#v", messenger.messages)
+		t.FailNow()
+	}
+	for i, expected := range []string{"2", "only admin can use /migrate_tokens", "send /help"} {
+		if !strings.Contains(messenger.messages[i].Text, expected) {
+			log.Printf("should reply %s: %s", expected, messenger.messages[i].Text)
+			t.Fail()
+		}
+	}
+	if help := messenger.messages[3].Text; !strings.Contains(help, "/new [channel_name]") || strings.Contains(help, "/start") {
+		log.Printf("admins should get all listed commands:\n%s", help)
+		t.Fail()
+	}
+	if start := messenger.messages[4].Text; !strings.HasPrefix(start, "Hi") || !strings.Contains(start, "/new") {
+		log.Printf("start should show the help:\n%s", start)
+		t.Fail()
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	a := testApp(&config{})
+	userHelp, ownerHelp := a.helpText(roleUser), a.helpText(roleOwner)
+	if !strings.Contains(userHelp, "/follow [channel_name] - Follow a channel") || strings.Contains(userHelp, "/token ") {
+		log.Printf("users should only get the follow commands:\n%s", userHelp)
+		t.Fail()
+	}
+	if !strings.Contains(ownerHelp, "/token [channel_name]") || strings.Contains(ownerHelp, "/new") {
+		log.Printf("owners should get the channel commands:\n%s", ownerHelp)
+		t.Fail()
+	}
+}
+
+func TestSyncCommands(t *testing.T) {
+	messenger := &fakeMessenger{}
+	a := testApp(&config{Telegram: telegramConfig{AdminChatID: 1, Admins: []int64{2}}})
+	a.messenger = messenger
+	a.syncCommands()
+
+	defaultMenu := messenger.menus[CommandScope{Type: "default"}]
+	groupMenu := messenger.menus[CommandScope{Type: "all_chat_administrators"}]
+	adminMenu := messenger.menus[CommandScope{Type: "chat", ChatID: 2}]
+	if len(messenger.menus) != 4 || len(defaultMenu) == 0 || len(groupMenu) <= len(defaultMenu) || len(adminMenu) <= len(groupMenu) {
+		log.Printf("wrong menus %#v", messenger.menus)
+		t.Fail()
+	}
+	for _, command := range defaultMenu {
+		if command.Command == "token" || command.Command == "new" || command.Command == "start" {
+			log.Printf("%s should not be in the default menu", command.Command)
+			t.Fail()
+		}
+	}
+}
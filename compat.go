@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compatible publish API for ntfy (https://ntfy.sh) and Gotify (https://gotify.net) clients.
+//
+// ntfy:   server url https://DOMAIN/ntfy, topic is the channel name, token as Bearer or Basic password.
+// gotify: server url https://DOMAIN/gotify, app token is "<channel>.<token>".
+
+type compatMessage struct {
+	Title    string
+	Message  string
+	Tags     []string
+	Priority int
+}
+
+func (a *App) registerCompatRoutes(router gin.IRouter) {
+	ntfyPublish := func(c *gin.Context) {
+		topic := c.Param("topic")
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			ntfyError(c, http.StatusBadRequest, "read request body failed")
+			return
+		}
+		msg := compatMessage{
+			Title:   ntfyParam(c, "title", "X-Title", "Title", "ti", "t"),
+			Message: string(body),
+			Tags:    splitTags(ntfyParam(c, "tags", "X-Tags", "Tags", "Tag", "ta")),
+		}
+		msg.Priority, err = parseNtfyPriority(ntfyParam(c, "priority", "X-Priority", "Priority", "prio", "p"))
+		if err != nil {
+			ntfyError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.ntfySend(c, topic, msg)
+	}
+	router.PUT("/ntfy/:topic", ntfyPublish)
+	router.POST("/ntfy/:topic", ntfyPublish)
+
+	// ntfy JSON publishing, topic in the body.
+	router.POST("/ntfy", func(c *gin.Context) {
+		var req struct {
+			Topic    string   `json:"topic"`
+			Message  string   `json:"message"`
+			Title    string   `json:"title"`
+			Tags     []string `json:"tags"`
+			Priority int      `json:"priority"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			ntfyError(c, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		if req.Priority == 0 {
+			req.Priority = 3
+		}
+		if req.Priority < 1 || req.Priority > 5 {
+			ntfyError(c, http.StatusBadRequest, "invalid priority")
+			return
+		}
+		a.ntfySend(c, req.Topic, compatMessage{
+			Title:    req.Title,
+			Message:  req.Message,
+			Tags:     req.Tags,
+			Priority: req.Priority,
+		})
+	})
+
+	router.POST("/gotify/message", func(c *gin.Context) {
+		var req struct {
+			Title    string `json:"title" form:"title"`
+			Message  string `json:"message" form:"message"`
+			Priority *int   `json:"priority" form:"priority"`
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			gotifyError(c, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		if req.Message == "" {
+			gotifyError(c, http.StatusBadRequest, "message required")
+			return
+		}
+		appToken := c.GetHeader("X-Gotify-Key")
+		if appToken == "" {
+			appToken = bearerToken(c.GetHeader("Authorization"))
+		}
+		inURL := false
+		if appToken == "" {
+			appToken, inURL = c.Query("token"), true
+		}
+		channelID, token, ok := strings.Cut(appToken, ".")
+		if !ok {
+			gotifyError(c, http.StatusUnauthorized, "app token required, format: <channel>.<token>")
+			return
+		}
+		// gotify default priority is 5, 0 means no notification.
+		priority := 5
+		if req.Priority != nil {
+			priority = *req.Priority
+		}
+		msg := compatMessage{Title: req.Title, Message: req.Message, Priority: priority}
+		_, err := a.sendToChannel(c.Request.Context(), sendAuth{ChannelID: channelID, Token: token, InURL: inURL, ClientIP: a.senderIP(c)}, &channelMessage{Text: msg.text(), Silent: priority == 0})
+		if err != nil {
+			gotifyError(c, compatStatus(c, err), err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":       time.Now().UnixNano(),
+			"appid":    0,
+			"message":  req.Message,
+			"title":    req.Title,
+			"priority": priority,
+			"date":     time.Now().Format(time.RFC3339),
+		})
+	})
+}
+
+func (a *App) ntfySend(c *gin.Context, topic string, msg compatMessage) {
+	token, inURL := ntfyToken(c)
+	if token == "" {
+		ntfyError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if msg.Message == "" {
+		msg.Message = "triggered"
+	}
+	// min and low priority are delivered without notification.
+	_, err := a.sendToChannel(c.Request.Context(), sendAuth{ChannelID: topic, Token: token, InURL: inURL, ClientIP: a.senderIP(c)}, &channelMessage{Text: msg.text(), Silent: msg.Priority <= 2})
+	if err != nil {
+		ntfyError(c, compatStatus(c, err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":       strconv.FormatInt(time.Now().UnixNano(), 36),
+		"time":     time.Now().Unix(),
+		"event":    "message",
+		"topic":    topic,
+		"title":    msg.Title,
+		"message":  msg.Message,
+		"priority": msg.Priority,
+		"tags":     msg.Tags,
+	})
+}
+
+// text renders the message as plain telegram text.
+func (m compatMessage) text() string {
+	var s strings.Builder
+	if m.Title != "" {
+		s.WriteString(m.Title)
+		s.WriteString("\n\n")
+	}
+	s.WriteString(m.Message)
+	if len(m.Tags) > 0 {
+		s.WriteString("\n\n#")
+		s.WriteString(strings.Join(m.Tags, " #"))
+	}
+	return s.String()
+}
+
+// ntfyParam returns the first non-empty value of the query parameter or the given headers.
+func ntfyParam(c *gin.Context, query string, headers ...string) string {
+	if v := c.Query(query); v != "" {
+		return v
+	}
+	for _, h := range headers {
+		if v := c.GetHeader(h); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ntfyToken accepts "Authorization: Bearer <token>" and basic auth with the token as password,
+// inURL is true when the token is from the ?auth= query.
+func ntfyToken(c *gin.Context) (token string, inURL bool) {
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password, false
+	}
+	if token := bearerToken(c.GetHeader("Authorization")); token != "" {
+		return token, false
+	}
+	// ntfy clients can pass the authorization header base64 encoded in ?auth=
+	if auth := c.Query("auth"); auth != "" {
+		header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(auth, "="))
+		if err == nil {
+			return bearerToken(string(header)), true
+		}
+	}
+	return "", false
+}
+
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func splitTags(tags string) []string {
+	list := make([]string, 0)
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
+
+// parseNtfyPriority accepts 1-5 and the ntfy priority names, empty means default(3).
+func parseNtfyPriority(p string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(p)) {
+	case "":
+		return 3, nil
+	case "1", "min":
+		return 1, nil
+	case "2", "low":
+		return 2, nil
+	case "3", "default":
+		return 3, nil
+	case "4", "high":
+		return 4, nil
+	case "5", "max", "urgent":
+		return 5, nil
+	}
+	return 0, errors.New("invalid priority")
+}
+
+// compatStatus returns the http status of a send error, Retry-After is set when rate limited.
+func compatStatus(c *gin.Context, err error) int {
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		c.Header("Retry-After", strconv.Itoa(limitErr.retryAfterSeconds()))
+		return http.StatusTooManyRequests
+	}
+	if err == errNoChannel || err == errURLTokenDisabled || err == errIPNotAllowed || err == errSignatureRequired {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func ntfyError(c *gin.Context, code int, message string) {
+	c.JSON(code, gin.H{"code": code * 100, "http": code, "error": message})
+}
+
+func gotifyError(c *gin.Context, code int, message string) {
+	c.JSON(code, gin.H{"error": http.StatusText(code), "errorCode": code, "errorDescription": message})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"testing"
+)
+
+func TestParseNtfyPriority(t *testing.T) {
+	list := make(map[string]int)
+	list[""] = 3
+	list["1"] = 1
+	list["min"] = 1
+	list["High"] = 4
+	list["urgent"] = 5
+	list["6"] = 0
+	list["x"] = 0
+
+	for p, expect := range list {
+		result, err := parseNtfyPriority(p)
+		if result != expect || (expect == 0) != (err != nil) {
+			log.Printf("[%s] should %d, got %d %v\n", p, expect, result, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestCompatMessageText(t *testing.T) {
+	msg := compatMessage{Title: "disk", Message: "sda full", Tags: splitTags("warning, nas,")}
+	if msg.text() != "disk\n\nsda full\n\n#warning #nas" {
+		log.Printf("unexpected text: %q\n", msg.text())
+		t.Fail()
+	}
+	msg = compatMessage{Message: "hello"}
+	if msg.text() != "hello" {
+		t.Fail()
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	if bearerToken("Bearer abc") != "abc" || bearerToken("bearer abc ") != "abc" {
+		t.Fail()
+	}
+	if bearerToken("Basic abc") != "" || bearerToken("") != "" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// config is read from the yaml or toml config file, env vars override the file.
+// secrets can be read from files with the *_file options.
+type config struct {
+	Telegram telegramConfig `yaml:"telegram" toml:"telegram"`
+	Firebase firebaseConfig `yaml:"firebase" toml:"firebase"`
+	Server   serverConfig   `yaml:"server" toml:"server"`
+	Webhook  webhookConfig  `yaml:"webhook" toml:"webhook"`
+	SMTP     smtpConfig     `yaml:"smtp" toml:"smtp"`
+	Syslog   syslogConfig   `yaml:"syslog" toml:"syslog"`
+	GRPC     grpcConfig     `yaml:"grpc" toml:"grpc"`
+	MQTT     mqttConfig     `yaml:"mqtt" toml:"mqtt"`
+	// more bots served by the same process, the telegram section is the main bot.
+	Bots []botConfig `yaml:"bots" toml:"bots"`
+
+	// loaded by validate.
+	syslogRules  []*syslogRule
+	mqttMappings []*mqttMapping
+}
+
+type telegramConfig struct {
+	Token     string `yaml:"token" toml:"token" env:"TELEGRAM_TOKEN"`
+	TokenFile string `yaml:"token_file" toml:"token_file" env:"TELEGRAM_TOKEN_FILE"`
+	// Bot API server, empty for api.telegram.org.
+	APIURL string `yaml:"api_url" toml:"api_url" env:"TELEGRAM_API_URL"`
+	// webhook or polling.
+	Mode        string `yaml:"mode" toml:"mode" env:"BOT_MODE"`
+	AdminChatID int64  `yaml:"admin_chat_id" toml:"admin_chat_id" env:"ADMIN_CHAT_ID"`
+	// more admins besides AdminChatID.
+	Admins []int64 `yaml:"admins" toml:"admins"`
+}
+
+// botConfig is an extra bot, the other options are shared with the main bot.
+// its channels are stored apart from the other bots and its api is served under /b/<name>.
+type botConfig struct {
+	Name      string `yaml:"name" toml:"name"`
+	Token     string `yaml:"token" toml:"token"`
+	TokenFile string `yaml:"token_file" toml:"token_file"`
+	// webhook path, the webhook secret is derived from the token.
+	BotURI string  `yaml:"bot_uri" toml:"bot_uri"`
+	Admins []int64 `yaml:"admins" toml:"admins"`
+}
+
+type firebaseConfig struct {
+	// base64 of the credentials json, see -tokenFile.
+	Token string `yaml:"token" toml:"token" env:"FIREBASE_TOKEN"`
+	// path of the credentials json.
+	CredentialsFile string `yaml:"credentials_file" toml:"credentials_file" env:"FIREBASE_CREDENTIALS_FILE"`
+}
+
+type serverConfig struct {
+	// listen on 127.0.0.1:9000 when empty.
+	Port           string   `yaml:"port" toml:"port" env:"PORT"`
+	Lambda         bool     `yaml:"lambda" toml:"lambda" env:"AWS_LAMBDA"`
+	Debug          bool     `yaml:"debug" toml:"debug" env:"DEBUG"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// e.g. "minute=60 day=5000"
+	ChannelRateLimit string `yaml:"channel_rate_limit" toml:"channel_rate_limit" env:"CHANNEL_RATE_LIMIT"`
+}
+
+type smtpConfig struct {
+	Listen string `yaml:"listen" toml:"listen" env:"SMTP_LISTEN"`
+	Domain string `yaml:"domain" toml:"domain" env:"SMTP_DOMAIN"`
+}
+
+type syslogConfig struct {
+	Listen string `yaml:"listen" toml:"listen" env:"SYSLOG_LISTEN"`
+	Rules  string `yaml:"rules" toml:"rules" env:"SYSLOG_RULES"`
+}
+
+type grpcConfig struct {
+	Listen    string   `yaml:"listen" toml:"listen" env:"GRPC_LISTEN"`
+	AdminKeys []string `yaml:"admin_keys" toml:"admin_keys" env:"GRPC_ADMIN_KEYS"`
+	// one key a line.
+	AdminKeysFile string `yaml:"admin_keys_file" toml:"admin_keys_file" env:"GRPC_ADMIN_KEYS_FILE"`
+	// tls certificate and key pem files, without them the keys are sent in plaintext.
+	CertFile string `yaml:"cert_file" toml:"cert_file" env:"GRPC_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" toml:"key_file" env:"GRPC_KEY_FILE"`
+}
+
+type mqttConfig struct {
+	Broker       string `yaml:"broker" toml:"broker" env:"MQTT_BROKER"`
+	Mappings     string `yaml:"mappings" toml:"mappings" env:"MQTT_MAPPINGS"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"MQTT_CLIENT_ID"`
+	Username     string `yaml:"username" toml:"username" env:"MQTT_USERNAME"`
+	Password     string `yaml:"password" toml:"password" env:"MQTT_PASSWORD"`
+	PasswordFile string `yaml:"password_file" toml:"password_file" env:"MQTT_PASSWORD_FILE"`
+}
+
+// loadConfig reads the config file, empty path means env vars only, then applies env vars and secret files.
+func loadConfig(path string, getenv func(string) string) (*config, error) {
+	cfg := &config{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.UnmarshalStrict(data, cfg)
+		case ".toml":
+			err = toml.NewDecoder(strings.NewReader(string(data))).DisallowUnknownFields().Decode(cfg)
+		default:
+			return nil, errors.New("config file should be .yaml, .yml or .toml")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), getenv); err != nil {
+		return nil, err
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// legacyFlagEnv were on for any non-empty value before the config file, e.g. DEBUG=yes keeps working.
+var legacyFlagEnv = map[string]bool{"DEBUG": true, "AWS_LAMBDA": true}
+
+// applyEnv sets the fields with an env tag from the non-empty env vars, lists are comma separated.
+func applyEnv(v reflect.Value, getenv func(string) string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, getenv); err != nil {
+				return err
+			}
+			continue
+		}
+		name := v.Type().Field(i).Tag.Get("env")
+		value := getenv(name)
+		if name == "" || value == "" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil && legacyFlagEnv[name] {
+				b, err = true, nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s should be true or false", name)
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s should be an integer", name)
+			}
+			field.SetInt(n)
+		case reflect.Slice:
+			field.Set(reflect.ValueOf(splitTags(value)))
+		}
+	}
+	return nil
+}
+
+func (cfg *config) readSecretFiles() error {
+	read := func(path string) (string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	var err error
+	if cfg.Telegram.TokenFile != "" {
+		if cfg.Telegram.Token, err = read(cfg.Telegram.TokenFile); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Bots {
+		if cfg.Bots[i].TokenFile != "" {
+			if cfg.Bots[i].Token, err = read(cfg.Bots[i].TokenFile); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.Firebase.CredentialsFile != "" {
+		credentials, err := read(cfg.Firebase.CredentialsFile)
+		if err != nil {
+			return err
+		}
+		cfg.Firebase.Token = base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	if cfg.Webhook.SecretFile != "" {
+		if cfg.Webhook.Secret, err = read(cfg.Webhook.SecretFile); err != nil {
+			return err
+		}
+	}
+	if cfg.GRPC.AdminKeysFile != "" {
+		keys, err := read(cfg.GRPC.AdminKeysFile)
+		if err != nil {
+			return err
+		}
+		cfg.GRPC.AdminKeys = strings.Fields(keys)
+	}
+	if cfg.MQTT.PasswordFile != "" {
+		if cfg.MQTT.Password, err = read(cfg.MQTT.PasswordFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the config and loads the syslog rules and mqtt mappings,
+// all problems are returned at once.
+func (cfg *config) validate() error {
+	errs := make([]error, 0)
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.Telegram.Token == "" {
+		fail("telegram token required (TELEGRAM_TOKEN or telegram.token)")
+	}
+	if cfg.Telegram.APIURL != "" {
+		if u, err := url.Parse(cfg.Telegram.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("telegram api_url should be like http://127.0.0.1:8081")
+		}
+	}
+	switch cfg.Telegram.Mode {
+	case "", "webhook":
+		if cfg.Webhook.Domain == "" || cfg.Webhook.BotURI == "" {
+			fail("webhook domain and bot_uri required (DOMAIN and BOT_URI), or use polling mode")
+		} else if u, err := url.Parse(cfg.Webhook.Domain + cfg.Webhook.BotURI); err != nil || u.Scheme != "https" || u.Host == "" {
+			fail("webhook url %q should be an https url", cfg.Webhook.Domain+"<bot_uri>")
+		}
+	case "polling":
+		if cfg.Server.Lambda {
+			fail("polling mode can't run on lambda")
+		}
+	default:
+		fail("telegram mode should be webhook or polling")
+	}
+
+	botNames := make(map[string]bool)
+	botURIs := map[string]bool{cfg.Webhook.BotURI: true}
+	for i, bot := range cfg.Bots {
+		if !checkChannelName(bot.Name) || botNames[bot.Name] {
+			fail("bot %d: name should be unique and only accept [a-zA-Z0-9_]", i)
+		}
+		botNames[bot.Name] = true
+		if bot.Token == "" {
+			fail("bot %s: token required", bot.Name)
+		}
+		if cfg.Telegram.Mode != "polling" {
+			if bot.BotURI == "" || botURIs[bot.BotURI] {
+				fail("bot %s: bot_uri required and should differ from the other bots", bot.Name)
+			}
+			botURIs[bot.BotURI] = true
+		}
+	}
+
+	if cfg.Firebase.Token == "" {
+		fail("firebase credentials required (FIREBASE_TOKEN or firebase.credentials_file)")
+	} else if credentials, err := base64.StdEncoding.DecodeString(cfg.Firebase.Token); err != nil {
+		fail("firebase token base64 decode failed: %s", err)
+	} else if !json.Valid(credentials) {
+		fail("firebase credentials should be json")
+	}
+
+	if cfg.Server.Port != "" {
+		if port, err := strconv.Atoi(cfg.Server.Port); err != nil || port < 1 || port > 65535 {
+			fail("port should be 1-65535")
+		}
+	}
+	if _, err := parseAllowedIPs(cfg.Server.TrustedProxies); err != nil {
+		fail("trusted_proxies: %s", err)
+	}
+	if cfg.Server.ChannelRateLimit != "" {
+		if _, err := parseRateLimit(strings.Fields(cfg.Server.ChannelRateLimit)); err != nil {
+			fail("channel_rate_limit: %s", err)
+		}
+	}
+
+	if cfg.Webhook.Secret != "" && !webhookSecretPattern.MatchString(cfg.Webhook.Secret) {
+		fail("webhook secret only accept [A-Za-z0-9_-], at most 256 characters")
+	}
+	switch cfg.Webhook.Register {
+	case "", "auto", "always", "skip":
+	default:
+		fail("webhook register should be auto, always or skip")
+	}
+	if cfg.Webhook.MaxConnections != 0 && (cfg.Webhook.MaxConnections < 1 || cfg.Webhook.MaxConnections > 100) {
+		fail("webhook max_connections should be 1-100")
+	}
+
+	if cfg.Syslog.Listen != "" {
+		rules, err := loadSyslogRules(cfg.Syslog.Rules)
+		if err != nil {
+			fail("load syslog rules failed: %s", err)
+		}
+		cfg.syslogRules = rules
+	}
+	if cfg.GRPC.Listen != "" && len(cfg.GRPC.AdminKeys) == 0 {
+		fail("grpc admin_keys required")
+	}
+	if (cfg.GRPC.CertFile == "") != (cfg.GRPC.KeyFile == "") {
+		fail("grpc cert_file and key_file should be set together")
+	}
+	if cfg.MQTT.Broker != "" {
+		mappings, err := loadMQTTMappings(cfg.MQTT.Mappings)
+		if err != nil {
+			fail("load mqtt mappings failed: %s", err)
+		}
+		cfg.mqttMappings = mappings
+	}
+	return errors.Join(errs...)
+}
+
+// forBot returns the config of an extra bot, the main bot options are replaced by the bot options.
+func (cfg *config) forBot(bot botConfig) *config {
+	c := *cfg
+	c.Telegram.Token = bot.Token
+	c.Telegram.AdminChatID = 0
+	c.Telegram.Admins = bot.Admins
+	c.Webhook.BotURI = bot.BotURI
+	c.Webhook.Secret = ""
+	c.Bots = nil
+	return &c
+}
+
+// listenAddr is the http listen address.
+func (cfg *config) listenAddr() string {
+	if cfg.Server.Port == "" {
+		return "127.0.0.1:9000"
+	}
+	return ":" + cfg.Server.Port
+}
+
+// redacted returns a copy with the secrets hidden, for config check.
+func (cfg *config) redacted() config {
+	c := *cfg
+	hide := func(s *string) {
+		if *s != "" {
+			*s = "***"
+		}
+	}
+	hide(&c.Telegram.Token)
+	hide(&c.Firebase.Token)
+	hide(&c.Webhook.BotURI)
+	hide(&c.Webhook.Secret)
+	hide(&c.MQTT.Password)
+	c.Bots = append([]botConfig(nil), c.Bots...)
+	for i := range c.Bots {
+		hide(&c.Bots[i].Token)
+		hide(&c.Bots[i].BotURI)
+	}
+	if len(c.GRPC.AdminKeys) > 0 {
+		c.GRPC.AdminKeys = []string{"***"}
+	}
+	return c
+}
+
+// configCheck validates the config and prints it without the secrets.
+func configCheck(path string) int {
+	cfg, err := loadConfig(path, os.Getenv)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config error:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out, _ := yaml.Marshal(cfg.redacted())
+	fmt.Print(string(out))
+	fmt.Println("config ok")
+	return 0
+}
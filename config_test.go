@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	credentials := writeTestFile(t, "firebase.json", `{"type": "service_account"}`)
+	tokenFile := writeTestFile(t, "token", "123:abc\n")
+	yamlFile := writeTestFile(t, "config.yaml", `
+telegram:
+  token_file: `+tokenFile+`
+  admin_chat_id: 42
+firebase:
+  credentials_file: `+credentials+`
+server:
+  port: "8080"
+  trusted_proxies: [10.0.0.0/8]
+webhook:
+  domain: https://example.com/
+  bot_uri: bot
+  max_connections: 10
+bots:
+  - name: staging
+    token_file: `+tokenFile+`
+    bot_uri: staging_bot
+    admins: [44]
+`)
+	tomlFile := writeTestFile(t, "config.toml", `
+[telegram]
+token_file = "`+tokenFile+`"
+admin_chat_id = 42
+
+[firebase]
+credentials_file = "`+credentials+`"
+
+[server]
+port = "8080"
+trusted_proxies = ["10.0.0.0/8"]
+
+[webhook]
+domain = "https://example.com/"
+bot_uri = "bot"
+max_connections = 10
+
+[[bots]]
+name = "staging"
+token_file = "`+tokenFile+`"
+bot_uri = "staging_bot"
+admins = [44]
+`)
+	env := map[string]string{"DEBUG": "true", "ADMIN_CHAT_ID": "43", "WEBHOOK_ALLOWED_UPDATES": "message,callback_query"}
+	for _, path := range []string{yamlFile, tomlFile} {
+		cfg, err := loadConfig(path, func(key string) string { return env[key] })
+		if err != nil {
+			log.Println(path, err)
+			t.FailNow()
+		}
+		if err := cfg.validate(); err != nil {
+			log.Println(path, err)
+			t.Fail()
+		}
+		firebase, _ := base64.StdEncoding.DecodeString(cfg.Firebase.Token)
+		if cfg.Telegram.Token != "123:abc" || string(firebase) != `{"type": "service_account"}` || cfg.listenAddr() != ":8080" {
+			log.Printf("%s: secret files not read %#v", path, cfg)
+			t.Fail()
+		}
+		if !cfg.Server.Debug || cfg.Telegram.AdminChatID != 43 || len(cfg.Webhook.AllowedUpdates) != 2 || cfg.Webhook.MaxConnections != 10 {
+			log.Printf("%s: env not applied %#v", path, cfg)
+			t.Fail()
+		}
+		if len(cfg.Bots) != 1 || cfg.Bots[0].Token != "123:abc" {
+			log.Printf("%s: bots not read %#v", path, cfg.Bots)
+			t.FailNow()
+		}
+		if redacted := cfg.redacted(); redacted.Telegram.Token != "***" || redacted.Bots[0].Token != "***" || cfg.Telegram.Token != "123:abc" || cfg.Bots[0].Token != "123:abc" {
+			log.Println("token should only be hidden in the copy")
+			t.Fail()
+		}
+		staging := cfg.forBot(cfg.Bots[0])
+		if staging.Webhook.BotURI != "staging_bot" || staging.Telegram.AdminChatID != 0 || staging.Webhook.Domain != cfg.Webhook.Domain || cfg.Webhook.BotURI != "bot" {
+			log.Printf("%s: wrong bot config %#v", path, staging)
+			t.Fail()
+		}
+	}
+
+	if _, err := loadConfig(writeTestFile(t, "config.yaml", "telegram:\n  tokn: abc\n"), func(string) string { return "" }); err == nil {
+		log.Println("unknown key should be rejected")
+		t.Fail()
+	}
+	cfg, err := loadConfig("", func(key string) string { return map[string]string{"DEBUG": "yes", "AWS_LAMBDA": "on"}[key] })
+	if err != nil || !cfg.Server.Debug || !cfg.Server.Lambda {
+		log.Println("legacy flags should be on for any value: ", err)
+		t.Fail()
+	}
+	if _, err := loadConfig("", func(key string) string { return map[string]string{"WEBHOOK_CHECK_IP": "yes"}[key] }); err == nil {
+		log.Println("wrong WEBHOOK_CHECK_IP should be rejected")
+		t.Fail()
+	}
+	if _, err := loadConfig("", func(key string) string { return map[string]string{"ADMIN_CHAT_ID": "me"}[key] }); err == nil || !strings.Contains(err.Error(), "ADMIN_CHAT_ID") {
+		log.Println("wrong ADMIN_CHAT_ID should be rejected: ", err)
+		t.Fail()
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := &config{}
+	cfg.Telegram.Mode = "polling"
+	cfg.Server.Lambda = true
+	cfg.Server.Port = "http"
+	cfg.Firebase.Token = "not base64"
+	cfg.Webhook.MaxConnections = 1000
+	cfg.GRPC.CertFile = "grpc.pem"
+	cfg.Bots = []botConfig{{Name: "staging"}, {Name: "staging", Token: "123:abc"}}
+	err := cfg.validate()
+	if err == nil {
+		t.FailNow()
+	}
+	for _, message := range []string{"telegram token required", "polling mode can't run on lambda", "port", "firebase", "max_connections", "bot staging: token required", "bot 1: name should be unique", "grpc cert_file and key_file"} {
+		if !strings.Contains(err.Error(), message) {
+			log.Printf("should report %s: %s", message, err)
+			t.Fail()
+		}
+	}
+}
@@ -3,7 +3,9 @@ package data
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
@@ -12,37 +14,52 @@ import (
 	"google.golang.org/grpc/codes"
 )
 
-var (
-	firebaseApp *firebase.App
-	store       *firestore.Client
-)
-
 type Channel struct {
 	ctx   context.Context
 	store *firestore.Client
-	db    *firestore.CollectionRef
+	// bot namespace, empty for the top level collections.
+	bot string
+	db  *firestore.CollectionRef
 }
 
 type ChannelData struct {
-	ID        string  `json:"id" firestore:"id"`
-	Token     string  `json:"token" firestore:"token"`
-	Owner     int64   `json:"owner" firestore:"owner"`
-	OwnerName string  `json:"owner_name" firestore:"owner_name"`
-	Users     []int64 `json:"users" firestore:"users"`
+	ID string `json:"id" firestore:"id"`
+	// plaintext token of channels created before token hashing, empty after migration.
+	Token         string    `json:"token" firestore:"token"`
+	TokenHash     string    `json:"token_hash" firestore:"token_hash"`
+	Owner         int64     `json:"owner" firestore:"owner"`
+	OwnerName     string    `json:"owner_name" firestore:"owner_name"`
+	Users         []int64   `json:"users" firestore:"users"`
+	TokenLastUsed time.Time `json:"token_last_used" firestore:"token_last_used"`
+	// extra named tokens with scopes.
+	Tokens []ChannelToken `json:"tokens" firestore:"tokens"`
+	// reject tokens in the url path or query.
+	DisableURLToken bool `json:"disable_url_token" firestore:"disable_url_token"`
+	// send requests must be signed with this secret when set.
+	SigningSecret string `json:"signing_secret" firestore:"signing_secret"`
+	// cidrs allowed to send, empty allows any source.
+	AllowedIPs []string `json:"allowed_ips" firestore:"allowed_ips"`
+	// tell the owner about senders rejected by AllowedIPs.
+	ReportRejectedIP bool `json:"report_rejected_ip" firestore:"report_rejected_ip"`
+	// limit of all messages to the channel.
+	RateLimit RateLimit `json:"rate_limit" firestore:"rate_limit"`
+	// limit of the default token, named tokens have their own.
+	DefaultTokenLimit RateLimit `json:"default_token_limit" firestore:"default_token_limit"`
+	// codes of follow links.
+	Invites []ChannelInvite `json:"invites" firestore:"invites"`
 }
 
+// NewChannel connects to firestore with the credentials json, ctx is used by all calls of the channel.
 func NewChannel(ctx context.Context, token []byte) (*Channel, error) {
 	firebaseOption := option.WithCredentialsJSON(token)
 	firebaseApp, err := firebase.NewApp(ctx, nil, firebaseOption)
 	if err != nil {
-		log.Fatalf("firebase app create failed err: %s", err.Error())
-		return nil, err
+		return nil, fmt.Errorf("firebase app create failed: %w", err)
 	}
 
-	store, err = firebaseApp.Firestore(ctx)
+	store, err := firebaseApp.Firestore(ctx)
 	if err != nil {
-		log.Fatalf("firebase store init failed err: %s", err.Error())
-		return nil, err
+		return nil, fmt.Errorf("firebase store init failed: %w", err)
 	}
 	return &Channel{
 		ctx:   ctx,
@@ -51,6 +68,30 @@ func NewChannel(ctx context.Context, token []byte) (*Channel, error) {
 	}, nil
 }
 
+// WithContext returns a copy of the channel using ctx, the connection is shared.
+func (c *Channel) WithContext(ctx context.Context) *Channel {
+	copy := *c
+	copy.ctx = ctx
+	return &copy
+}
+
+// ForBot returns the channels of the named bot, stored under bot/<name>.
+// the connection is shared, close the channel it was created from.
+func (c *Channel) ForBot(name string) *Channel {
+	copy := *c
+	copy.bot = name
+	copy.db = copy.collection("channel")
+	return &copy
+}
+
+// collection returns the collection in the bot namespace.
+func (c *Channel) collection(name string) *firestore.CollectionRef {
+	if c.bot == "" {
+		return c.store.Collection(name)
+	}
+	return c.store.Collection("bot").Doc(c.bot).Collection(name)
+}
+
 func (c *Channel) Close() {
 	c.store.Close()
 }
@@ -72,8 +113,17 @@ func (c *Channel) Get(ID string) (*ChannelData, error) {
 }
 
 func (c *Channel) GetAll() ([]ChannelData, error) {
+	return readAll(c.db.Documents(c.ctx))
+}
+
+// GetOwned returns the channels owned by the chat.
+func (c *Channel) GetOwned(owner int64) ([]ChannelData, error) {
+	return readAll(c.db.Where("owner", "==", owner).Documents(c.ctx))
+}
+
+func readAll(docs *firestore.DocumentIterator) ([]ChannelData, error) {
 	list := make([]ChannelData, 0)
-	iter, err := c.db.Documents(c.ctx).GetAll()
+	iter, err := docs.GetAll()
 	if err != nil {
 		return list, err
 	}
@@ -113,6 +163,33 @@ func (c *Channel) Remove(ID string) error {
 	return nil
 }
 
+// ErrNoChannel is returned by the transactional updates when the channel doesn't exist.
+var ErrNoChannel = errors.New("channel not exists")
+
+// transact reads the channel in a transaction and applies the field updates returned by fn,
+// so changes saved by others since the caller read the channel are kept.
+func (c *Channel) transact(ID string, fn func(data *ChannelData) ([]firestore.Update, error)) error {
+	ref := c.db.Doc(ID)
+	return c.store.RunTransaction(c.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if grpc.Code(err) == codes.NotFound {
+			return ErrNoChannel
+		}
+		if err != nil {
+			return err
+		}
+		var data ChannelData
+		if err := doc.DataTo(&data); err != nil {
+			return err
+		}
+		updates, err := fn(&data)
+		if err != nil || len(updates) == 0 {
+			return err
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
 func (c *Channel) Update(data *ChannelData) error {
 	res, err := c.db.Doc(data.ID).Set(c.ctx, data)
 	if err != nil {
@@ -6,6 +6,9 @@ import (
 	"log"
 	"os"
 	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
 )
 
 var (
@@ -109,3 +112,22 @@ func TestUpdate(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestForBot(t *testing.T) {
+	client, err := firestore.NewClient(context.Background(), "test", option.WithoutAuthentication())
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	defer client.Close()
+	c := &Channel{ctx: context.Background(), store: client, db: client.Collection("channel")}
+	staging := c.ForBot("staging")
+	if staging.db.Path != "projects/test/databases/(default)/documents/bot/staging/channel" {
+		log.Println("wrong collection: ", staging.db.Path)
+		t.Fail()
+	}
+	if staging.collection("limit").ID != "limit" || c.collection("limit").Path != "projects/test/databases/(default)/documents/limit" {
+		log.Println("wrong limit collection: ", c.collection("limit").Path)
+		t.Fail()
+	}
+}
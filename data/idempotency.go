@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// sendResult is the result of a send with an Idempotency-Key, empty while the first send is delivering.
+// expired documents are removed by a firestore TTL policy on expires_at.
+type sendResult struct {
+	Result    string    `firestore:"result"`
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+// ReserveSendKey reserves the idempotency key before the send, the keys are shared by all instances.
+// it returns true if the key is new, otherwise the result of the first send, empty while it's delivering.
+func (c *Channel) ReserveSendKey(key string, ttl time.Duration, now time.Time) (string, bool, error) {
+	ref := c.sendKeyDoc(key)
+	result, first := "", false
+	err := c.store.RunTransaction(c.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		result, first = "", false
+		doc, err := tx.Get(ref)
+		if err != nil && grpc.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var stored sendResult
+			if err := doc.DataTo(&stored); err != nil {
+				return err
+			}
+			// the TTL policy removes expired documents some time later.
+			if now.Before(stored.ExpiresAt) {
+				result = stored.Result
+				return nil
+			}
+		}
+		first = true
+		return tx.Set(ref, sendResult{ExpiresAt: now.Add(ttl)})
+	})
+	return result, first, err
+}
+
+// SaveSendResult stores the result of the send which reserved the key.
+func (c *Channel) SaveSendResult(key, result string) error {
+	_, err := c.sendKeyDoc(key).Update(c.ctx, []firestore.Update{{Path: "result", Value: result}})
+	return err
+}
+
+// ReleaseSendKey removes the key of a send which failed before delivering, so it can be retried.
+func (c *Channel) ReleaseSendKey(key string) error {
+	_, err := c.sendKeyDoc(key).Delete(c.ctx)
+	return err
+}
+
+func (c *Channel) sendKeyDoc(key string) *firestore.DocumentRef {
+	// the key is chosen by the client, it may contain / which is not allowed in document ids.
+	sum := sha256.Sum256([]byte(key))
+	return c.collection("idempotency").Doc(hex.EncodeToString(sum[:]))
+}
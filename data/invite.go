@@ -0,0 +1,101 @@
+package data
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// errors of FollowWithInvite.
+var (
+	ErrInvalidInvite = errors.New("invite invalid or expired")
+	ErrFollowOwner   = errors.New("can't follow the channel you owned")
+	ErrFollowed      = errors.New("already followed")
+)
+
+// ChannelInvite is the code of a follow link, shared by the owner so users don't have to type the channel name.
+type ChannelInvite struct {
+	Code string `json:"code" firestore:"code"`
+	// removed on the first follow.
+	SingleUse bool `json:"single_use" firestore:"single_use"`
+	// zero means never expires.
+	ExpiresAt time.Time `json:"expires_at" firestore:"expires_at"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	Uses      int       `json:"uses" firestore:"uses"`
+}
+
+// Expired checks the invite expiry.
+func (i *ChannelInvite) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt)
+}
+
+// AddInvite adds an invite code, expired invites are removed.
+func (c *ChannelData) AddInvite(code string, singleUse bool, expiresAt, now time.Time) {
+	invites := c.Invites[:0]
+	for _, invite := range c.Invites {
+		if !invite.Expired(now) {
+			invites = append(invites, invite)
+		}
+	}
+	c.Invites = append(invites, ChannelInvite{
+		Code:      code,
+		SingleUse: singleUse,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	})
+}
+
+// UseInvite checks the invite code and records the use, a single use invite is removed.
+// the channel should be saved when it returns true.
+func (c *ChannelData) UseInvite(code string, now time.Time) bool {
+	if code == "" {
+		return false
+	}
+	for i := range c.Invites {
+		invite := &c.Invites[i]
+		if subtle.ConstantTimeCompare([]byte(invite.Code), []byte(code)) != 1 {
+			continue
+		}
+		if invite.Expired(now) {
+			return false
+		}
+		if invite.SingleUse {
+			c.Invites = append(c.Invites[:i], c.Invites[i+1:]...)
+			return true
+		}
+		invite.Uses++
+		return true
+	}
+	return false
+}
+
+// FollowWithInvite uses the invite code and adds the user to the followers in one transaction,
+// so a single use invite can't be used by two users at once.
+func (c *Channel) FollowWithInvite(ID string, userID int64, code string, now time.Time) error {
+	err := c.transact(ID, func(data *ChannelData) ([]firestore.Update, error) {
+		return data.followUpdates(userID, code, now)
+	})
+	if err == ErrNoChannel {
+		return ErrInvalidInvite
+	}
+	return err
+}
+
+// followUpdates uses the invite and adds the follower, the invite is kept when the user can't follow.
+func (c *ChannelData) followUpdates(userID int64, code string, now time.Time) ([]firestore.Update, error) {
+	if userID == c.Owner {
+		return nil, ErrFollowOwner
+	}
+	for _, user := range c.Users {
+		if user == userID {
+			return nil, ErrFollowed
+		}
+	}
+	if !c.UseInvite(code, now) {
+		return nil, ErrInvalidInvite
+	}
+	c.Users = append(c.Users, userID)
+	return []firestore.Update{{Path: "users", Value: c.Users}, {Path: "invites", Value: c.Invites}}, nil
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestUseInvite(t *testing.T) {
+	now := time.Now()
+	c := &ChannelData{ID: "test"}
+	c.AddInvite("office", false, time.Time{}, now)
+	c.AddInvite("once", true, time.Time{}, now)
+	c.AddInvite("old", false, now.Add(-time.Hour), now.Add(-2*time.Hour))
+
+	type item struct {
+		code string
+		ok   bool
+	}
+	list := []item{
+		{"office", true},
+		{"office", true},
+		{"once", true},
+		{"once", false},
+		{"old", false},
+		{"wrong", false},
+		{"", false},
+	}
+	for _, i := range list {
+		if ok := c.UseInvite(i.code, now); ok != i.ok {
+			log.Printf("[%s] should %t, got %t\n", i.code, i.ok, ok)
+			t.Fail()
+		}
+	}
+	if len(c.Invites) != 2 || c.Invites[0].Uses != 2 {
+		log.Printf("wrong invites %#v", c.Invites)
+		t.Fail()
+	}
+
+	c.AddInvite("new", true, time.Time{}, now)
+	if len(c.Invites) != 2 || c.Invites[1].Code != "new" {
+		log.Printf("expired invites should be removed %#v", c.Invites)
+		t.Fail()
+	}
+}
+
+func TestFollowUpdates(t *testing.T) {
+	now := time.Now()
+	c := &ChannelData{ID: "test", Owner: 1, Users: []int64{4}}
+	c.AddInvite("once", true, time.Time{}, now)
+
+	// the owner and followers keep the invite for others.
+	if _, err := c.followUpdates(1, "once", now); err != ErrFollowOwner || len(c.Invites) != 1 {
+		log.Printf("owner follow: %v %d invites", err, len(c.Invites))
+		t.Fail()
+	}
+	if _, err := c.followUpdates(4, "once", now); err != ErrFollowed || len(c.Invites) != 1 {
+		log.Printf("follower follow: %v %d invites", err, len(c.Invites))
+		t.Fail()
+	}
+	updates, err := c.followUpdates(2, "once", now)
+	if err != nil || len(updates) != 2 || len(c.Users) != 2 || len(c.Invites) != 0 {
+		log.Printf("follow: %v %#v", err, updates)
+		t.Fail()
+	}
+	if _, err := c.followUpdates(3, "once", now); err != ErrInvalidInvite {
+		log.Println("single use invite used twice: ", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// RateLimit is a token bucket of PerMinute messages a minute holding up to Burst messages,
+// and a quota of Daily messages a UTC day. zero values are unlimited.
+type RateLimit struct {
+	PerMinute int `json:"per_minute" firestore:"per_minute"`
+	// bucket size, PerMinute when zero.
+	Burst int `json:"burst" firestore:"burst"`
+	Daily int `json:"daily" firestore:"daily"`
+}
+
+// IsZero is true when nothing is limited.
+func (l RateLimit) IsZero() bool {
+	return l.PerMinute <= 0 && l.Daily <= 0
+}
+
+// LimitState is the counter of a RateLimit, stored in the limit collection.
+type LimitState struct {
+	// messages left in the bucket at Updated.
+	Tokens  float64   `json:"tokens" firestore:"tokens"`
+	Updated time.Time `json:"updated" firestore:"updated"`
+	// UTC date of Count, 2006-01-02.
+	Day   string `json:"day" firestore:"day"`
+	Count int    `json:"count" firestore:"count"`
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.PerMinute)
+}
+
+// Take takes one message from the state, the state is only changed when allowed.
+// retryAfter is the wait until the next message is allowed.
+func (l RateLimit) Take(state *LimitState, now time.Time) (allowed bool, retryAfter time.Duration) {
+	now = now.UTC()
+	day := now.Format("2006-01-02")
+	count := state.Count
+	if state.Day != day {
+		count = 0
+	}
+	if l.Daily > 0 && count >= l.Daily {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return false, tomorrow.Sub(now)
+	}
+
+	tokens := state.Tokens
+	if l.PerMinute > 0 {
+		tokens = l.burst()
+		if !state.Updated.IsZero() {
+			refill := math.Max(0, now.Sub(state.Updated).Minutes()) * float64(l.PerMinute)
+			tokens = math.Min(tokens, state.Tokens+refill)
+		}
+		if tokens < 1 {
+			wait := (1 - tokens) / float64(l.PerMinute) * float64(time.Minute)
+			return false, time.Duration(math.Ceil(wait))
+		}
+		tokens--
+	}
+
+	state.Tokens = tokens
+	state.Updated = now
+	state.Day = day
+	state.Count = count + 1
+	return true, 0
+}
+
+// TokenRateLimit returns the limit of the named token.
+func (c *ChannelData) TokenRateLimit(name string) RateLimit {
+	if name == DefaultTokenName {
+		return c.DefaultTokenLimit
+	}
+	if t := c.NamedToken(name); t != nil {
+		return t.RateLimit
+	}
+	return RateLimit{}
+}
+
+// SetTokenRateLimit sets the limit of the named token, it returns false if the token not exists.
+func (c *ChannelData) SetTokenRateLimit(name string, limit RateLimit) bool {
+	if name == DefaultTokenName {
+		c.DefaultTokenLimit = limit
+		return true
+	}
+	t := c.NamedToken(name)
+	if t == nil {
+		return false
+	}
+	t.RateLimit = limit
+	return true
+}
+
+// TakeLimits takes one message from every limit in one transaction, the counters are shared by all instances.
+// keys are the document ids of the counters. nothing is taken unless all limits allow it.
+func (c *Channel) TakeLimits(keys []string, limits []RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	db := c.collection("limit")
+	err = c.store.RunTransaction(c.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		allowed, retryAfter = true, 0
+		states := make([]LimitState, len(keys))
+		for i, key := range keys {
+			doc, err := tx.Get(db.Doc(key))
+			if err != nil && grpc.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				if err := doc.DataTo(&states[i]); err != nil {
+					return err
+				}
+			}
+		}
+		for i, limit := range limits {
+			ok, wait := limit.Take(&states[i], now)
+			if !ok {
+				allowed = false
+				if wait > retryAfter {
+					retryAfter = wait
+				}
+			}
+		}
+		if !allowed {
+			return nil
+		}
+		for i, key := range keys {
+			if err := tx.Set(db.Doc(key), states[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTake(t *testing.T) {
+	now := time.Date(2023, 1, 1, 23, 58, 0, 0, time.UTC)
+	limit := RateLimit{PerMinute: 2, Burst: 3, Daily: 5}
+	state := &LimitState{}
+	for i := 0; i < 3; i++ {
+		if ok, _ := limit.Take(state, now); !ok {
+			log.Printf("burst message %d should be allowed", i)
+			t.Fail()
+		}
+	}
+	ok, retryAfter := limit.Take(state, now)
+	if ok || retryAfter != 30*time.Second {
+		log.Println("bucket should be empty: ", ok, retryAfter)
+		t.Fail()
+	}
+	if ok, _ := limit.Take(state, now.Add(30*time.Second)); !ok {
+		log.Println("bucket should be refilled")
+		t.Fail()
+	}
+	if ok, _ := limit.Take(state, now.Add(60*time.Second)); !ok {
+		log.Println("daily quota not used up")
+		t.Fail()
+	}
+	ok, retryAfter = limit.Take(state, now.Add(90*time.Second))
+	if ok || retryAfter != 30*time.Second {
+		log.Println("daily quota should be used up until midnight: ", ok, retryAfter)
+		t.Fail()
+	}
+	if ok, _ := limit.Take(state, now.Add(3*time.Minute)); !ok || state.Count != 1 {
+		log.Println("daily quota should reset on the next day: ", state.Count)
+		t.Fail()
+	}
+
+	unlimited := RateLimit{}
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.Take(state, now); !ok {
+			t.Fail()
+		}
+	}
+}
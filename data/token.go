@@ -0,0 +1,223 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// token hash format: sha256$<salt hex>$<sha256(salt+token) hex>
+const tokenHashPrefix = "sha256$"
+
+// HashToken returns the salted hash of the token.
+func HashToken(token string) string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return tokenHashPrefix + hex.EncodeToString(salt) + "$" + hex.EncodeToString(tokenSum(salt, token))
+}
+
+func tokenSum(salt []byte, token string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// CheckTokenHash compares the token with the hash in constant time.
+func CheckTokenHash(hash, token string) bool {
+	if !strings.HasPrefix(hash, tokenHashPrefix) {
+		return false
+	}
+	saltHex, sumHex, ok := strings.Cut(strings.TrimPrefix(hash, tokenHashPrefix), "$")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return false
+	}
+	sum, err := hex.DecodeString(sumHex)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(sum, tokenSum(salt, token)) == 1
+}
+
+// CheckToken checks the channel token, legacy plaintext tokens are still accepted.
+func (c *ChannelData) CheckToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	if c.TokenHash != "" {
+		return CheckTokenHash(c.TokenHash, token)
+	}
+	return c.Token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1
+}
+
+// SetToken stores the hash of the token and removes the plaintext token.
+func (c *ChannelData) SetToken(token string) {
+	c.TokenHash = HashToken(token)
+	c.Token = ""
+}
+
+// HasPlaintextToken is true for channels created before tokens were hashed.
+func (c *ChannelData) HasPlaintextToken() bool {
+	return c.Token != ""
+}
+
+const (
+	// ScopeSend allows sending messages to the channel.
+	ScopeSend = "send"
+	// ScopeSubscribers allows listing and managing the channel subscribers.
+	ScopeSubscribers = "subscribers"
+
+	// DefaultTokenName is the name of the channel token, it has all scopes.
+	DefaultTokenName = "default"
+
+	// last used time is only saved when older than this, to avoid a write on every use.
+	tokenTouchInterval = time.Minute
+)
+
+// Scopes is the list of valid token scopes.
+var Scopes = []string{ScopeSend, ScopeSubscribers}
+
+// ChannelToken is an extra named token of a channel.
+type ChannelToken struct {
+	Name   string   `json:"name" firestore:"name"`
+	Hash   string   `json:"hash" firestore:"hash"`
+	Scopes []string `json:"scopes" firestore:"scopes"`
+	// zero means never expires.
+	ExpiresAt time.Time `json:"expires_at" firestore:"expires_at"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	LastUsed  time.Time `json:"last_used" firestore:"last_used"`
+	RateLimit RateLimit `json:"rate_limit" firestore:"rate_limit"`
+}
+
+// HasScope checks the token has the scope, empty scope means any scope.
+func (t *ChannelToken) HasScope(scope string) bool {
+	if scope == "" {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired checks the token expiry.
+func (t *ChannelToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// Authorize checks the token is the channel token or a valid named token with the scope,
+// it returns the token name.
+func (c *ChannelData) Authorize(token, scope string, now time.Time) (string, bool) {
+	if c.CheckToken(token) {
+		return DefaultTokenName, true
+	}
+	if token == "" {
+		return "", false
+	}
+	for i := range c.Tokens {
+		t := &c.Tokens[i]
+		if CheckTokenHash(t.Hash, token) {
+			return t.Name, t.HasScope(scope) && !t.Expired(now)
+		}
+	}
+	return "", false
+}
+
+// NamedToken returns the named token, nil if not exists.
+func (c *ChannelData) NamedToken(name string) *ChannelToken {
+	for i := range c.Tokens {
+		if c.Tokens[i].Name == name {
+			return &c.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// AddToken adds a named token, an existing token with the same name is replaced.
+func (c *ChannelData) AddToken(name, token string, scopes []string, expiresAt, now time.Time) {
+	c.RevokeToken(name)
+	c.Tokens = append(c.Tokens, ChannelToken{
+		Name:      name,
+		Hash:      HashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	})
+}
+
+// RevokeToken removes the named token, it returns false if not exists.
+func (c *ChannelData) RevokeToken(name string) bool {
+	for i := range c.Tokens {
+		if c.Tokens[i].Name == name {
+			c.Tokens = append(c.Tokens[:i], c.Tokens[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// TouchToken records the use of the named token,
+// it returns true when the channel should be saved.
+func (c *ChannelData) TouchToken(name string, now time.Time) bool {
+	lastUsed := &c.TokenLastUsed
+	if name != DefaultTokenName {
+		t := c.NamedToken(name)
+		if t == nil {
+			return false
+		}
+		lastUsed = &t.LastUsed
+	}
+	if now.Sub(*lastUsed) < tokenTouchInterval {
+		return false
+	}
+	*lastUsed = now
+	return true
+}
+
+// SaveTokenUse saves the last used time of the token, only when the token is still valid,
+// so a token rotated or revoked since it was checked is not brought back.
+func (c *Channel) SaveTokenUse(ID, tokenName, token string, now time.Time) error {
+	return c.transact(ID, func(data *ChannelData) ([]firestore.Update, error) {
+		return data.tokenUseUpdates(tokenName, token, now), nil
+	})
+}
+
+// tokenUseUpdates touches the token and returns the changed fields, none if the token is no longer valid.
+func (c *ChannelData) tokenUseUpdates(tokenName, token string, now time.Time) []firestore.Update {
+	name, ok := c.Authorize(token, "", now)
+	if !ok || name != tokenName || !c.TouchToken(tokenName, now) {
+		return nil
+	}
+	if tokenName == DefaultTokenName {
+		return []firestore.Update{{Path: "token_last_used", Value: c.TokenLastUsed}}
+	}
+	return []firestore.Update{{Path: "tokens", Value: c.Tokens}}
+}
+
+// HashPlaintextToken replaces the legacy plaintext channel token with its hash,
+// it returns false if the channel has no plaintext token.
+func (c *Channel) HashPlaintextToken(ID string) (bool, error) {
+	hashed := false
+	err := c.transact(ID, func(data *ChannelData) ([]firestore.Update, error) {
+		if !data.HasPlaintextToken() {
+			return nil, nil
+		}
+		data.SetToken(data.Token)
+		hashed = true
+		return []firestore.Update{{Path: "token", Value: ""}, {Path: "token_hash", Value: data.TokenHash}}, nil
+	})
+	return hashed, err
+}
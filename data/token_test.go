@@ -0,0 +1,102 @@
+package data
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestTokenHash(t *testing.T) {
+	hash := HashToken("tgm_secret")
+	if hash == HashToken("tgm_secret") {
+		// salted
+		t.Fail()
+	}
+	if !CheckTokenHash(hash, "tgm_secret") || CheckTokenHash(hash, "tgm_other") || CheckTokenHash("tgm_secret", "tgm_secret") {
+		t.Fail()
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	legacy := &ChannelData{ID: "test", Token: "abcdef"}
+	if !legacy.CheckToken("abcdef") || legacy.CheckToken("abcdeg") || legacy.CheckToken("") || !legacy.HasPlaintextToken() {
+		t.Fail()
+	}
+
+	legacy.SetToken("abcdef")
+	if legacy.Token != "" || legacy.HasPlaintextToken() || !legacy.CheckToken("abcdef") || legacy.CheckToken("abcdeg") {
+		t.Fail()
+	}
+
+	empty := &ChannelData{ID: "test"}
+	if empty.CheckToken("") {
+		t.Fail()
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	now := time.Now()
+	c := &ChannelData{ID: "test"}
+	c.SetToken("main")
+	c.AddToken("ci", "ci_token", []string{ScopeSend}, time.Time{}, now)
+	c.AddToken("old", "old_token", []string{ScopeSend, ScopeSubscribers}, now.Add(-time.Hour), now)
+
+	type item struct {
+		token, scope, name string
+		ok                 bool
+	}
+	list := []item{
+		{"main", ScopeSubscribers, DefaultTokenName, true},
+		{"ci_token", ScopeSend, "ci", true},
+		{"ci_token", ScopeSubscribers, "ci", false},
+		{"ci_token", "", "ci", true},
+		{"old_token", ScopeSend, "old", false},
+		{"wrong", ScopeSend, "", false},
+		{"", ScopeSend, "", false},
+	}
+	for _, i := range list {
+		name, ok := c.Authorize(i.token, i.scope, now)
+		if name != i.name || ok != i.ok {
+			log.Printf("[%s %s] should %s %t, got %s %t\n", i.token, i.scope, i.name, i.ok, name, ok)
+			t.Fail()
+		}
+	}
+
+	if !c.TouchToken("ci", now) || c.TouchToken("ci", now.Add(time.Second)) || c.NamedToken("ci").LastUsed != now {
+		t.Fail()
+	}
+	if !c.TouchToken(DefaultTokenName, now) || c.TokenLastUsed != now {
+		t.Fail()
+	}
+
+	if !c.RevokeToken("ci") || c.RevokeToken("ci") || c.NamedToken("ci") != nil {
+		t.Fail()
+	}
+	if _, ok := c.Authorize("ci_token", ScopeSend, now); ok {
+		t.Fail()
+	}
+}
+
+func TestTokenUseUpdates(t *testing.T) {
+	now := time.Now()
+	c := &ChannelData{ID: "test"}
+	c.SetToken("main")
+	c.AddToken("ci", "ci_token", []string{ScopeSend}, time.Time{}, now)
+
+	if updates := c.tokenUseUpdates("ci", "ci_token", now); len(updates) != 1 || updates[0].Path != "tokens" {
+		log.Printf("wrong updates %#v", updates)
+		t.Fail()
+	}
+	if updates := c.tokenUseUpdates(DefaultTokenName, "main", now); len(updates) != 1 || updates[0].Path != "token_last_used" {
+		log.Printf("wrong updates %#v", updates)
+		t.Fail()
+	}
+	// revoked or rotated after the send checked it.
+	c.RevokeToken("ci")
+	c.SetToken("rotated")
+	later := now.Add(time.Hour)
+	if c.tokenUseUpdates("ci", "ci_token", later) != nil || c.tokenUseUpdates(DefaultTokenName, "main", later) != nil {
+		log.Println("invalid tokens should not be saved")
+		t.Fail()
+	}
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// processedUpdate marks a telegram update or a signature nonce as used, expired documents are removed by
+// a firestore TTL policy on expires_at.
+type processedUpdate struct {
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+// MarkUpdate records the update id, it returns false if the update was already recorded.
+func (c *Channel) MarkUpdate(updateID int, ttl time.Duration, now time.Time) (bool, error) {
+	return c.markOnce("update", strconv.Itoa(updateID), ttl, now)
+}
+
+// MarkNonce records the signature nonce of the channel, it returns false if the nonce was already used.
+// the nonces are shared by all instances, so a replay to another instance is rejected.
+func (c *Channel) MarkNonce(channelID, nonce string, ttl time.Duration, now time.Time) (bool, error) {
+	// the nonce is chosen by the client, it may contain / which is not allowed in document ids.
+	sum := sha256.Sum256([]byte(channelID + "\n" + nonce))
+	return c.markOnce("nonce", hex.EncodeToString(sum[:]), ttl, now)
+}
+
+// markOnce creates the document, it returns false if it already exists.
+func (c *Channel) markOnce(collection, id string, ttl time.Duration, now time.Time) (bool, error) {
+	doc := c.collection(collection).Doc(id)
+	_, err := doc.Create(c.ctx, processedUpdate{ExpiresAt: now.Add(ttl)})
+	if grpc.Code(err) == codes.AlreadyExists {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
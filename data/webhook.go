@@ -0,0 +1,38 @@
+package data
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// webhookRegistration is the last setWebhook of the bot, telegram doesn't return the secret token
+// so the registration is recorded to know the secret is set.
+type webhookRegistration struct {
+	// hash of the url and secret.
+	Hash         string    `firestore:"hash"`
+	RegisteredAt time.Time `firestore:"registered_at"`
+}
+
+// WebhookRegistration returns the hash of the last registration, empty if never recorded.
+func (c *Channel) WebhookRegistration() (string, error) {
+	doc, err := c.collection("webhook").Doc("registration").Get(c.ctx)
+	if grpc.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var registration webhookRegistration
+	if err := doc.DataTo(&registration); err != nil {
+		return "", err
+	}
+	return registration.Hash, nil
+}
+
+// SaveWebhookRegistration records a successful setWebhook.
+func (c *Channel) SaveWebhookRegistration(hash string, now time.Time) error {
+	_, err := c.collection("webhook").Doc("registration").Set(c.ctx, webhookRegistration{Hash: hash, RegisteredAt: now})
+	return err
+}
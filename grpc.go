@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net"
+	"time"
+
+	d "github.com/hitian/telegram-messager/data"
+	"github.com/hitian/telegram-messager/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements pb.MessagerServer, see proto/messager.proto.
+type grpcServer struct {
+	pb.UnimplementedMessagerServer
+	app       *App
+	adminKeys []string
+}
+
+// serveGRPC serves the grpc api, with tls when the cert and key files are set.
+func (a *App) serveGRPC(cfg grpcConfig) error {
+	var options []grpc.ServerOption
+	if cfg.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, grpc.Creds(creds))
+	} else {
+		a.logger.Println("grpc without tls, tokens and admin keys are sent in plaintext, put it behind a tls proxy")
+	}
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	a.logger.Printf("grpc listen Addr: %s, tls: %t\n", cfg.Listen, cfg.CertFile != "")
+	s := grpc.NewServer(options...)
+	pb.RegisterMessagerServer(s, &grpcServer{app: a, adminKeys: cfg.AdminKeys})
+	return s.Serve(l)
+}
+
+// authKey returns the key of the "authorization: Bearer <key>" metadata.
+func authKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if key := bearerToken(v); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+func (s *grpcServer) isAdmin(ctx context.Context) bool {
+	key := authKey(ctx)
+	if key == "" {
+		return false
+	}
+	for _, adminKey := range s.adminKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// channel loads the channel, the caller should be admin or has a channel token with the scope.
+// empty scope means any valid token.
+func (s *grpcServer) channel(ctx context.Context, ch *d.Channel, channelID, scope string) (*d.ChannelData, error) {
+	channelInfo, _, err := s.authorizedChannel(ctx, ch, channelID, scope)
+	return channelInfo, err
+}
+
+// authorizedChannel is channel and also returns the token name, empty for admin keys.
+func (s *grpcServer) authorizedChannel(ctx context.Context, ch *d.Channel, channelID, scope string) (*d.ChannelData, string, error) {
+	if channelID == "" {
+		return nil, "", status.Error(codes.InvalidArgument, "channel required")
+	}
+	key := authKey(ctx)
+	if key == "" {
+		return nil, "", status.Error(codes.Unauthenticated, "authorization required")
+	}
+	channelInfo, err := ch.Get(channelID)
+	if err != nil {
+		s.app.logger.Println("fetch channel info failed:", err)
+		return nil, "", status.Error(codes.Internal, "fetch channel info failed")
+	}
+	if s.isAdmin(ctx) {
+		if channelInfo == nil {
+			return nil, "", status.Error(codes.NotFound, "channel not exist")
+		}
+		return channelInfo, "", nil
+	}
+	if channelInfo == nil {
+		return nil, "", status.Error(codes.PermissionDenied, errNoChannel.Error())
+	}
+	tokenName, ok := channelInfo.Authorize(key, scope, time.Now())
+	if !ok {
+		return nil, "", status.Error(codes.PermissionDenied, errNoChannel.Error())
+	}
+	// grpc calls can't be signed.
+	if scope == d.ScopeSend {
+		if err := checkSigned(channelInfo, false); err != nil {
+			return nil, "", status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	if scope == d.ScopeSend {
+		if ip := peerIP(ctx); ip != "" {
+			if err := s.app.checkSenderIP(channelInfo, tokenName, ip); err != nil {
+				return nil, "", status.Error(codes.PermissionDenied, err.Error())
+			}
+		}
+	}
+	// after the allowlist, so rejected senders don't count as a use.
+	s.app.recordTokenUse(ch, channelInfo, tokenName, key)
+	return channelInfo, tokenName, nil
+}
+
+func (s *grpcServer) requireAdmin(ctx context.Context) error {
+	if authKey(ctx) == "" {
+		return status.Error(codes.Unauthenticated, "authorization required")
+	}
+	if !s.isAdmin(ctx) {
+		return status.Error(codes.PermissionDenied, "admin key required")
+	}
+	return nil
+}
+
+func (s *grpcServer) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.SendMessageResponse, error) {
+	if req.Text == "" && len(req.Attachments) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "text or attachments required")
+	}
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, tokenName, err := s.authorizedChannel(ctx, ch, req.Channel, d.ScopeSend)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.app.checkSendLimit(ch, channelInfo, tokenName, time.Now()); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	count := s.app.deliverToChannel(channelInfo, pbChannelMessage(req))
+	return &pb.SendMessageResponse{Receivers: int32(count)}, nil
+}
+
+func (s *grpcServer) SendMessages(stream pb.Messager_SendMessagesServer) error {
+	ctx := stream.Context()
+	ch := s.app.store.WithContext(ctx)
+
+	result := &pb.SendMessagesResponse{}
+	// channels are loaded once per stream.
+	type streamChannel struct {
+		info      *d.ChannelData
+		tokenName string
+	}
+	channels := make(map[string]streamChannel)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(result)
+		}
+		if err != nil {
+			return err
+		}
+		channel, ok := channels[req.Channel]
+		if !ok {
+			channel.info, channel.tokenName, err = s.authorizedChannel(ctx, ch, req.Channel, d.ScopeSend)
+			if err != nil {
+				result.Failed++
+				result.Errors = append(result.Errors, req.Channel+": "+status.Convert(err).Message())
+				continue
+			}
+			channels[req.Channel] = channel
+		}
+		if req.Text == "" && len(req.Attachments) == 0 {
+			result.Failed++
+			result.Errors = append(result.Errors, req.Channel+": text or attachments required")
+			continue
+		}
+		if err := s.app.checkSendLimit(ch, channel.info, channel.tokenName, time.Now()); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, req.Channel+": "+err.Error())
+			continue
+		}
+		s.app.deliverToChannel(channel.info, pbChannelMessage(req))
+		result.Sent++
+	}
+}
+
+func (s *grpcServer) CreateChannel(ctx context.Context, req *pb.CreateChannelRequest) (*pb.Channel, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if !checkChannelName(req.Id) {
+		return nil, status.Error(codes.InvalidArgument, "name only accept [a-zA-Z0-9_]")
+	}
+	if req.Owner == 0 {
+		return nil, status.Error(codes.InvalidArgument, "owner required")
+	}
+	ch := s.app.store.WithContext(ctx)
+
+	token := generateToken()
+	data := &d.ChannelData{
+		ID:        req.Id,
+		Owner:     req.Owner,
+		OwnerName: req.OwnerName,
+		Users:     []int64{},
+	}
+	data.SetToken(token)
+	if err := ch.Create(data); err != nil {
+		s.app.logger.Println("Error: ", err)
+		return nil, status.Error(codes.AlreadyExists, "create channel failed")
+	}
+	result := pbChannel(data)
+	result.Token = token
+	return result, nil
+}
+
+func (s *grpcServer) GetChannel(ctx context.Context, req *pb.GetChannelRequest) (*pb.Channel, error) {
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, err := s.channel(ctx, ch, req.Id, "")
+	if err != nil {
+		return nil, err
+	}
+	if s.isAdmin(ctx) {
+		return pbChannel(channelInfo), nil
+	}
+	return pbChannelForToken(channelInfo, authKey(ctx), time.Now()), nil
+}
+
+func (s *grpcServer) ListChannels(ctx context.Context, req *pb.ListChannelsRequest) (*pb.ListChannelsResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	ch := s.app.store.WithContext(ctx)
+	list, err := ch.GetAll()
+	if err != nil {
+		s.app.logger.Println("Error: ", err)
+		return nil, status.Error(codes.Internal, "fetch list error")
+	}
+	result := &pb.ListChannelsResponse{}
+	for i := range list {
+		result.Channels = append(result.Channels, pbChannel(&list[i]))
+	}
+	return result, nil
+}
+
+func (s *grpcServer) DeleteChannel(ctx context.Context, req *pb.DeleteChannelRequest) (*pb.DeleteChannelResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	ch := s.app.store.WithContext(ctx)
+	if _, err := s.channel(ctx, ch, req.Id, ""); err != nil {
+		return nil, err
+	}
+	if err := ch.Remove(req.Id); err != nil {
+		s.app.logger.Println("Error: ", err)
+		return nil, status.Error(codes.Internal, "remove channel failed")
+	}
+	return &pb.DeleteChannelResponse{}, nil
+}
+
+func (s *grpcServer) ListSubscribers(ctx context.Context, req *pb.ListSubscribersRequest) (*pb.ListSubscribersResponse, error) {
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, err := s.channel(ctx, ch, req.Channel, d.ScopeSubscribers)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListSubscribersResponse{Subscribers: channelInfo.Users}, nil
+}
+
+func (s *grpcServer) AddSubscriber(ctx context.Context, req *pb.SubscriberRequest) (*pb.ListSubscribersResponse, error) {
+	return s.updateSubscribers(ctx, req, func(channelInfo *d.ChannelData) error {
+		if req.UserId == channelInfo.Owner {
+			return status.Error(codes.InvalidArgument, "owner can't follow the channel")
+		}
+		for _, user := range channelInfo.Users {
+			if user == req.UserId {
+				return status.Error(codes.AlreadyExists, "already followed")
+			}
+		}
+		channelInfo.Users = append(channelInfo.Users, req.UserId)
+		return nil
+	})
+}
+
+func (s *grpcServer) RemoveSubscriber(ctx context.Context, req *pb.SubscriberRequest) (*pb.ListSubscribersResponse, error) {
+	return s.updateSubscribers(ctx, req, func(channelInfo *d.ChannelData) error {
+		for i, user := range channelInfo.Users {
+			if user == req.UserId {
+				channelInfo.Users = append(channelInfo.Users[:i], channelInfo.Users[i+1:]...)
+				return nil
+			}
+		}
+		return status.Error(codes.NotFound, "user not found")
+	})
+}
+
+func (s *grpcServer) updateSubscribers(ctx context.Context, req *pb.SubscriberRequest, update func(*d.ChannelData) error) (*pb.ListSubscribersResponse, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id required")
+	}
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, err := s.channel(ctx, ch, req.Channel, d.ScopeSubscribers)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(channelInfo); err != nil {
+		return nil, err
+	}
+	if err := ch.Update(channelInfo); err != nil {
+		s.app.logger.Println("update channel info failed ", err)
+		return nil, status.Error(codes.Internal, "update failed")
+	}
+	return &pb.ListSubscribersResponse{Subscribers: channelInfo.Users}, nil
+}
+
+func (s *grpcServer) CreateToken(ctx context.Context, req *pb.CreateTokenRequest) (*pb.TokenSecret, error) {
+	var expiresAt time.Time
+	if req.ExpiresAt > 0 {
+		expiresAt = time.Unix(req.ExpiresAt, 0)
+	}
+	var token string
+	err := s.updateChannel(ctx, req.Channel, func(channelInfo *d.ChannelData) (err error) {
+		token, err = addChannelToken(channelInfo, req.Name, req.Scopes, expiresAt, time.Now())
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TokenSecret{Name: req.Name, Token: token}, nil
+}
+
+func (s *grpcServer) RotateToken(ctx context.Context, req *pb.RotateTokenRequest) (*pb.TokenSecret, error) {
+	name := req.Name
+	if name == "" {
+		name = d.DefaultTokenName
+	}
+	var token string
+	err := s.updateChannel(ctx, req.Channel, func(channelInfo *d.ChannelData) (err error) {
+		token, err = rotateChannelToken(channelInfo, name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TokenSecret{Name: name, Token: token}, nil
+}
+
+func (s *grpcServer) ListTokens(ctx context.Context, req *pb.ListTokensRequest) (*pb.ListTokensResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, err := s.channel(ctx, ch, req.Channel, "")
+	if err != nil {
+		return nil, err
+	}
+	result := &pb.ListTokensResponse{Tokens: []*pb.Token{{
+		Name:     d.DefaultTokenName,
+		Scopes:   d.Scopes,
+		LastUsed: unixTime(channelInfo.TokenLastUsed),
+	}}}
+	for _, t := range channelInfo.Tokens {
+		result.Tokens = append(result.Tokens, &pb.Token{
+			Name:      t.Name,
+			Scopes:    t.Scopes,
+			ExpiresAt: unixTime(t.ExpiresAt),
+			CreatedAt: unixTime(t.CreatedAt),
+			LastUsed:  unixTime(t.LastUsed),
+		})
+	}
+	return result, nil
+}
+
+func (s *grpcServer) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	err := s.updateChannel(ctx, req.Channel, func(channelInfo *d.ChannelData) error {
+		if req.Name == d.DefaultTokenName {
+			return status.Error(codes.InvalidArgument, "the default token can't be revoked, use RotateToken")
+		}
+		if !channelInfo.RevokeToken(req.Name) {
+			return status.Error(codes.NotFound, "token not found")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+// updateChannel applies update to the channel and saves it, admin only.
+func (s *grpcServer) updateChannel(ctx context.Context, channelID string, update func(*d.ChannelData) error) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	ch := s.app.store.WithContext(ctx)
+	channelInfo, err := s.channel(ctx, ch, channelID, "")
+	if err != nil {
+		return err
+	}
+	if err := update(channelInfo); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := ch.Update(channelInfo); err != nil {
+		s.app.logger.Println("update channel info failed ", err)
+		return status.Error(codes.Internal, "update failed")
+	}
+	return nil
+}
+
+func (s *grpcServer) SetAllowedIPs(ctx context.Context, req *pb.SetAllowedIPsRequest) (*pb.Channel, error) {
+	allowed, err := parseAllowedIPs(req.AllowedIps)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var result *pb.Channel
+	err = s.updateChannel(ctx, req.Channel, func(channelInfo *d.ChannelData) error {
+		channelInfo.AllowedIPs = allowed
+		channelInfo.ReportRejectedIP = req.ReportRejected
+		result = pbChannel(channelInfo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func pbChannel(data *d.ChannelData) *pb.Channel {
+	return &pb.Channel{
+		Id:               data.ID,
+		Owner:            data.Owner,
+		OwnerName:        data.OwnerName,
+		Subscribers:      data.Users,
+		AllowedIps:       data.AllowedIPs,
+		ReportRejectedIp: data.ReportRejectedIP,
+	}
+}
+
+// pbChannelForToken leaves out the followers unless the token can list them.
+func pbChannelForToken(data *d.ChannelData, token string, now time.Time) *pb.Channel {
+	result := pbChannel(data)
+	if _, ok := data.Authorize(token, d.ScopeSubscribers, now); !ok {
+		result.Subscribers = nil
+	}
+	return result
+}
+
+func pbChannelMessage(req *pb.SendMessageRequest) *channelMessage {
+	msg := &channelMessage{Text: req.Text, Silent: req.Silent}
+	for _, a := range req.Attachments {
+		msg.Attachments = append(msg.Attachments, attachment{Name: a.Name, Data: a.Data})
+	}
+	return msg
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	d "github.com/hitian/telegram-messager/data"
+	"github.com/hitian/telegram-messager/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func grpcContext(key string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+key))
+}
+
+func TestGRPCAdminAuth(t *testing.T) {
+	s := &grpcServer{adminKeys: []string{"admin_key"}}
+	if !s.isAdmin(grpcContext("admin_key")) || s.isAdmin(grpcContext("other")) || s.isAdmin(context.Background()) {
+		t.Fail()
+	}
+
+	_, err := s.CreateChannel(context.Background(), &pb.CreateChannelRequest{Id: "test", Owner: 1})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fail()
+	}
+	_, err = s.ListChannels(grpcContext("channel_token"), &pb.ListChannelsRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fail()
+	}
+	_, err = s.CreateChannel(grpcContext("admin_key"), &pb.CreateChannelRequest{Id: "bad name", Owner: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fail()
+	}
+}
+
+func TestPBChannel(t *testing.T) {
+	data := &d.ChannelData{ID: "test", Owner: 1, Users: []int64{2, 3}}
+	data.SetToken("secret")
+	if pbChannel(data).Token != "" || len(pbChannel(data).Subscribers) != 2 {
+		t.Fail()
+	}
+}
+
+func TestPBChannelForToken(t *testing.T) {
+	now := time.Now()
+	data := &d.ChannelData{ID: "test", Owner: 1, Users: []int64{2, 3}}
+	data.SetToken("secret")
+	data.AddToken("ci", "ci_token", []string{d.ScopeSend}, time.Time{}, now)
+	data.AddToken("audit", "audit_token", []string{d.ScopeSubscribers}, time.Time{}, now)
+	if len(pbChannelForToken(data, "ci_token", now).Subscribers) != 0 {
+		t.Fail()
+	}
+	if len(pbChannelForToken(data, "audit_token", now).Subscribers) != 2 || len(pbChannelForToken(data, "secret", now).Subscribers) != 2 {
+		t.Fail()
+	}
+}
+
+func TestServeGRPCTLS(t *testing.T) {
+	a := testApp(&config{})
+	err := a.serveGRPC(grpcConfig{Listen: "127.0.0.1:0", CertFile: "missing.pem", KeyFile: "missing.key"})
+	if err == nil {
+		t.Fail()
+	}
+}
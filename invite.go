@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/skip2/go-qrcode"
+)
+
+// invite links of channels, t.me/<bot>?start=follow_<channel>_<invite> opens the bot and follows the channel.
+
+const (
+	// the limit of start payloads.
+	maxStartPayload = 64
+	// pixels of the qr code image, readable from across a room.
+	inviteQRSize = 512
+)
+
+var errInviteChannelName = errors.New("channel name too long for an invite link")
+
+// generateInviteCode returns a random code, without _ as it ends the channel name in the payload.
+func generateInviteCode() string {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// inviteLink returns the deep link of the invite.
+func inviteLink(botName, channelID, code string) (string, error) {
+	payload := followPayloadPrefix + channelID + "_" + code
+	if len(payload) > maxStartPayload {
+		return "", errInviteChannelName
+	}
+	return "https://t.me/" + botName + "?start=" + payload, nil
+}
+
+// inviteQRCode returns the png qr code of the link, for posting on office screens.
+func inviteQRCode(link string) ([]byte, error) {
+	return qrcode.Encode(link, qrcode.Medium, inviteQRSize)
+}
+
+func (a *App) botCommandInvite(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("channel invite: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) < 1 || len(params) > 3 {
+		return buildBotResponse(message, "wrong params, invite [channel_name] [once] [expiry: 30d, 12h or 2006-01-02]")
+	}
+	now := time.Now()
+	singleUse := false
+	var expiresAt time.Time
+	for _, param := range params[1:] {
+		if param == "once" {
+			singleUse = true
+			continue
+		}
+		var err error
+		if expiresAt, err = parseExpiry(param, now); err != nil {
+			return buildBotResponse(message, err.Error())
+		}
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+	code := generateInviteCode()
+	link, err := inviteLink(a.username, channelInfo.ID, code)
+	if err != nil {
+		return buildBotResponse(message, err.Error())
+	}
+	channelInfo.AddInvite(code, singleUse, expiresAt, now)
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+
+	png, err := inviteQRCode(link)
+	if err != nil {
+		a.logger.Println("generate qr code failed ", err)
+	} else {
+		photo := OutgoingMedia{ChatID: message.Chat.ID, Kind: mediaPhoto, Name: channelInfo.ID + ".png", Data: png, Caption: "follow " + channelInfo.ID}
+		if _, err := a.messenger.SendMedia(photo); err != nil {
+			a.logger.Println("send qr code failed ", err)
+		}
+	}
+
+	uses := "any number of times"
+	if singleUse {
+		uses = "once"
+	}
+	return buildBotResponse(message, fmt.Sprintf("invite link of %s, can be used %s, expires: %s\n\n%s", channelInfo.ID, uses, formatTime(expiresAt), link))
+}
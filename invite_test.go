@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestInviteLink(t *testing.T) {
+	code := generateInviteCode()
+	if len(code) != 10 || strings.Contains(code, "_") {
+		log.Println("wrong invite code: ", code)
+		t.Fail()
+	}
+	link, err := inviteLink("alert_bot", "prod_alerts", code)
+	if err != nil || link != "https://t.me/alert_bot?start=follow_prod_alerts_"+code {
+		log.Println("wrong link: ", link, err)
+		t.FailNow()
+	}
+	channel, parsed, ok := parseFollowPayload(strings.SplitN(link, "?start=", 2)[1])
+	if !ok || channel != "prod_alerts" || parsed != code {
+		log.Println("link should be parsed by /start: ", channel, parsed)
+		t.Fail()
+	}
+	if _, err := inviteLink("alert_bot", strings.Repeat("a", 50), code); err != errInviteChannelName {
+		log.Println("payload over 64 chars should be rejected: ", err)
+		t.Fail()
+	}
+
+	image, err := inviteQRCode(link)
+	if err != nil {
+		t.FailNow()
+	}
+	if decoded, err := png.Decode(bytes.NewReader(image)); err != nil || decoded.Bounds().Dx() != inviteQRSize {
+		log.Println("qr code should be a png: ", err)
+		t.Fail()
+	}
+}
@@ -2,15 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"runtime"
@@ -18,23 +19,29 @@ import (
 	"strings"
 	"time"
 
-	"github.com/apex/gateway"
 	"github.com/gin-gonic/gin"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	d "github.com/hitian/telegram-messager/data"
 )
 
+const (
+	tokenPrefix = "tgm_"
+	// how long an Idempotency-Key returns the first result.
+	sendResultTTL = 24 * time.Hour
+)
+
 var (
-	firebaseToken    []byte
-	telegramToken    = ""
-	webhookURLPrefix string
-	botURI           string
-	adminChatID      int64
-	isLambda         bool
-	isDebug          = false
-	build            = ""
+	build = ""
+
+	tokenShownOnce = "the token is only shown once, save it now."
+
+	errNoChannel        = errors.New("channel not exist or token not match")
+	errURLTokenDisabled = errors.New("token in url is disabled for this channel, use Authorization: Bearer header")
+
+	markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
 
 	encodeFirebaseTokenFile = flag.String("tokenFile", "", "firebase token file path")
+	configFile              = flag.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml config file path")
 )
 
 func main() {
@@ -44,44 +51,36 @@ func main() {
 		fmt.Println(result)
 		os.Exit(0)
 	}
-
-	firebaseToken = parseFirebaseToken(os.Getenv("FIREBASE_TOKEN"))
-	telegramToken = os.Getenv("TELEGRAM_TOKEN")
-	if telegramToken == "" {
-		log.Fatal("telegram token empty")
+	if flag.Arg(0) == "config" && flag.Arg(1) == "check" {
+		os.Exit(configCheck(*configFile))
 	}
 
-	webhookURLPrefix = os.Getenv("DOMAIN")
-	botURI = os.Getenv("BOT_URI")
-	adminChatIDString := os.Getenv("ADMIN_CHAT_ID")
-	adminChatID, _ = strconv.ParseInt(adminChatIDString, 10, 64)
-	isLambda = os.Getenv("AWS_LAMBDA") != ""
-	isDebug = os.Getenv("DEBUG") != ""
-
-	listenAddr := "127.0.0.1:9000"
-	if portENV := os.Getenv("PORT"); portENV != "" {
-		listenAddr = ":" + portENV
+	cfg, err := loadConfig(*configFile, os.Getenv)
+	if err != nil {
+		log.Fatalf("load config failed: %s", err)
 	}
-	log.Printf("listen Addr: %s\n", listenAddr)
-	log.Printf("webhookURLPrefix: %s\n", webhookURLPrefix)
-	log.Printf("adminChatID: %d\n", adminChatID)
-	log.Printf("is lambda: %t\n", isLambda)
-
-	router := createRouter()
-
-	initTelegramBot(router)
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("config error:\n%s", err)
+	}
+	log.Printf("webhookURLPrefix: %s\n", cfg.Webhook.Domain)
+	log.Printf("adminChatID: %d\n", cfg.Telegram.AdminChatID)
+	log.Printf("is lambda: %t\n", cfg.Server.Lambda)
 
-	if isLambda {
-		log.Fatal(gateway.ListenAndServe(listenAddr, router))
-	} else {
-		//start http server.
-		log.Fatal(router.Run(listenAddr))
+	server, err := NewServer(cfg)
+	if err != nil {
+		log.Fatalf("init failed: %s", err)
 	}
+	err = server.Run(context.Background())
+	server.Close()
+	log.Fatal(err)
 }
 
-func createRouter() *gin.Engine {
+func (s *Server) createRouter() (*gin.Engine, error) {
 	r := gin.New()
-	r.Use(gin.Logger())
+	if err := r.SetTrustedProxies(s.cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("wrong TRUSTED_PROXIES: %s", err)
+	}
+	r.Use(gin.LoggerWithFormatter(s.redactedLogFormatter))
 	r.Use(gin.Recovery())
 
 	r.GET("/", func(c *gin.Context) {
@@ -89,99 +88,80 @@ func createRouter() *gin.Engine {
 	})
 
 	r.GET("/sysinfo", func(c *gin.Context) {
-		c.String(http.StatusOK, fmt.Sprintf("Build: %s\nNumGoroutine: %d\nGo version: %s", build, runtime.NumGoroutine(), runtime.Version()))
+		c.String(http.StatusOK, fmt.Sprintf("Build: %s\nNumGoroutine: %d\nGo version: %s", build, runtime.NumGoroutine(), runtime.Version())+s.webhookSysinfo())
 	})
 
-	return r
+	return r, nil
 }
 
-func initTelegramBot(router *gin.Engine) {
-	if telegramToken == "" {
-		log.Println("WARNING: telegramToken not exists. skip bot init.")
-		return
-	}
-	bot, err := tgbotapi.NewBotAPI(telegramToken)
-	if err != nil {
-		log.Fatal(err)
-	}
-	bot.Debug = isDebug
-
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+// redactedLogFormatter is the gin default log format with tokens removed from the path.
+func (s *Server) redactedLogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		s.redactURL(param.Path),
+		param.ErrorMessage,
+	)
+}
 
-	_, err = bot.SetWebhook(tgbotapi.NewWebhook(webhookURLPrefix + botURI))
-	if err != nil {
-		log.Fatal(err)
+// redactURL hides the token of /send/:name/:token routes, the bot webhook paths and token query params.
+func (s *Server) redactURL(rawURL string) string {
+	path, query, hasQuery := strings.Cut(rawURL, "?")
+	segments := strings.Split(path, "/")
+	// the send api of extra bots is under /b/<name>.
+	send := 1
+	if len(segments) >= 3 && segments[1] == "b" {
+		send = 3
+	}
+	if len(segments) >= send+3 && segments[send] == "send" {
+		segments[send+2] = "***"
+	}
+	for _, a := range s.apps {
+		if botURI := a.cfg.Webhook.BotURI; botURI != "" && strings.TrimPrefix(path, "/") == botURI {
+			segments = []string{"", "***"}
+		}
 	}
-	info, err := bot.GetWebhookInfo()
-	if err != nil {
-		log.Fatal(err)
+	path = strings.Join(segments, "/")
+	if !hasQuery {
+		return path
 	}
-	if info.LastErrorDate != 0 {
-		log.Printf("Telegram callback failed: %s", info.LastErrorMessage)
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return path + "?***"
 	}
-
-	router.POST("/"+botURI, func(c *gin.Context) {
-		bytes, _ := ioutil.ReadAll(c.Request.Body)
-		if isDebug {
-			log.Println("callback received:", string(bytes))
+	for _, key := range []string{"token", "auth"} {
+		if values.Has(key) {
+			values.Set(key, "***")
 		}
-		var update tgbotapi.Update
-		err := json.Unmarshal(bytes, &update)
-		if err != nil {
-			log.Printf("callback data decode failed: %s \n%s", err, string(bytes))
-			c.String(http.StatusInternalServerError, "request recode failed.")
-			return
-		}
-
-		if update.Message != nil {
-			log.Printf("%d[%s] %s ", update.Message.Chat.ID, update.Message.From.UserName, update.Message.Text)
-			botMessageProcess(bot, update.Message)
-		}
-		c.String(http.StatusOK, "OK")
-	})
+	}
+	return path + "?" + values.Encode()
+}
 
-	send := func(c *gin.Context, channelID, token, data string) error {
+// registerSendRoutes adds the send api routes.
+func (a *App) registerSendRoutes(router gin.IRouter) {
+	send := func(c *gin.Context, auth sendAuth, data string) error {
 		defer func() {
 			if err := recover(); err != nil {
 				c.String(http.StatusBadRequest, fmt.Sprintf("Error: %s", err))
 			}
 		}()
 
-		if channelID == "" || token == "" || data == "" {
-			return errors.New("wrong params")
-		}
-
-		ch, err := d.NewChannel(c.Request.Context(), firebaseToken)
-		if err != nil {
-			log.Println("db connect failed: ", err)
-			return errors.New("db connect failed with error")
-		}
-		defer ch.Close()
-		channelInfo, err := ch.Get(channelID)
+		count, err := a.sendToChannel(c.Request.Context(), auth, &channelMessage{Text: decodeMessage(data)})
 		if err != nil {
-			log.Println("fetch channel info failed:", err)
-			return errors.New("fetch channel info failed with error")
+			return err
 		}
-		if channelInfo == nil || channelInfo.Token != token {
-			return errors.New("channel not exist or token not match")
-		}
-
-		message := decodeMessage(data) + "\n\nFrom [" + channelInfo.ID + "]"
-		//send to owner
-		tMessage := tgbotapi.NewMessage(channelInfo.Owner, message)
-		bot.Send(tMessage)
-
-		//send to all users
-		for _, userID := range channelInfo.Users {
-			tMessage := tgbotapi.NewMessage(userID, message)
-			bot.Send(tMessage)
-		}
-
-		c.String(http.StatusOK, fmt.Sprintf("ok, send to %d user", len(channelInfo.Users)+1))
+		c.String(http.StatusOK, fmt.Sprintf("ok, send to %d user", count))
 		return nil
 	}
 
-	router.GET("/send/:name/:token/:data", func(c *gin.Context) {
+	// deprecated, the token in the url ends up in access logs and proxies, use Authorization header.
+	router.GET("/send/:name/:token/:data", a.signatureMiddleware, func(c *gin.Context) {
 		channelName := c.Param("name")
 		token := c.Param("token")
 		data := c.Param("data")
@@ -189,13 +169,14 @@ func initTelegramBot(router *gin.Engine) {
 			c.String(http.StatusBadRequest, "need more params")
 			return
 		}
-		err := send(c, channelName, token, data)
+		err := send(c, sendAuth{ChannelID: channelName, Token: token, InURL: true, ClientIP: a.senderIP(c), Signed: c.GetBool(signedContextKey), Channel: loadedChannel(c)}, data)
 		if err != nil {
-			c.String(http.StatusBadRequest, err.Error())
+			writeSendError(c, err)
 		}
 	})
 
-	router.POST("/send/:name/:token", func(c *gin.Context) {
+	// deprecated, use POST /send/:name with Authorization header.
+	router.POST("/send/:name/:token", a.signatureMiddleware, func(c *gin.Context) {
 		channelName := c.Param("name")
 		token := c.Param("token")
 		body, err := ioutil.ReadAll(c.Request.Body)
@@ -208,89 +189,332 @@ func initTelegramBot(router *gin.Engine) {
 			c.String(http.StatusBadRequest, "need more params")
 			return
 		}
-		err = send(c, channelName, token, data)
+		err = send(c, sendAuth{ChannelID: channelName, Token: token, InURL: true, ClientIP: a.senderIP(c), Signed: c.GetBool(signedContextKey), Channel: loadedChannel(c)}, data)
 		if err != nil {
-			c.String(http.StatusBadRequest, err.Error())
+			writeSendError(c, err)
 		}
 	})
 
-	router.POST("/send", func(c *gin.Context) {
-		channelName := c.GetHeader("X-ChannelName")
-		token := c.GetHeader("X-ChannelToken")
-		if channelName == "" || token == "" {
+	sendWithOptions := func(c *gin.Context, auth sendAuth) {
+		if auth.ChannelID == "" || auth.Token == "" {
 			c.String(http.StatusBadRequest, "need more params")
 			return
 		}
-		body, err := ioutil.ReadAll(c.Request.Body)
+		msg, err := readSendRequest(c)
 		if err != nil {
-			c.String(http.StatusBadRequest, "read request body failed.")
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		data := string(body)
-		if len(body) < 1 {
-			c.String(http.StatusBadRequest, "request body required.")
+
+		ch, channelInfo, tokenName, err := a.authorizeSend(c.Request.Context(), auth)
+		if err != nil {
+			writeSendError(c, err)
 			return
 		}
-		err = send(c, channelName, token, data)
+
+		// the same Idempotency-Key of a channel token within 24 hours returns the first result.
+		// it's checked after the token, so the result is not shown without credentials.
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if idempotencyKey != "" {
+			idempotencyKey = auth.ChannelID + "/" + tokenName + "/" + idempotencyKey
+			result, replay, err := a.reserveSendKey(ch, idempotencyKey)
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if replay && result == "" {
+				c.String(http.StatusConflict, "a request with the same Idempotency-Key is in progress")
+				return
+			}
+			if replay {
+				c.Header("Idempotent-Replayed", "true")
+				c.String(http.StatusOK, result)
+				return
+			}
+		}
+
+		count, err := a.sendAuthorized(ch, channelInfo, tokenName, msg)
 		if err != nil {
-			c.String(http.StatusBadRequest, err.Error())
+			if idempotencyKey != "" {
+				a.finishSendKey(idempotencyKey, "")
+			}
+			writeSendError(c, err)
+			return
+		}
+		result := fmt.Sprintf("ok, send to %d user", count)
+		if idempotencyKey != "" {
+			a.finishSendKey(idempotencyKey, result)
 		}
+		c.String(http.StatusOK, result)
+	}
+
+	router.POST("/send", a.signatureMiddleware, func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" {
+			token = c.GetHeader("X-ChannelToken")
+		}
+		sendWithOptions(c, sendAuth{ChannelID: c.GetHeader("X-ChannelName"), Token: token, ClientIP: a.senderIP(c), Signed: c.GetBool(signedContextKey), Channel: loadedChannel(c)})
 	})
+
+	router.POST("/send/:name", a.signatureMiddleware, func(c *gin.Context) {
+		sendWithOptions(c, sendAuth{ChannelID: c.Param("name"), Token: bearerToken(c.GetHeader("Authorization")), ClientIP: a.senderIP(c), Signed: c.GetBool(signedContextKey), Channel: loadedChannel(c)})
+	})
+
+	a.registerCompatRoutes(router)
+}
+
+// channelMessage is a message pushed to the followers of a channel.
+type channelMessage struct {
+	Text string
+	// tgbotapi.ModeMarkdown or tgbotapi.ModeHTML, empty for plain text.
+	ParseMode   string
+	Silent      bool
+	Attachments []attachment
+}
+
+type attachment struct {
+	Name string
+	Data []byte
+}
+
+// sendAuth is the credential of a send request.
+type sendAuth struct {
+	ChannelID string
+	Token     string
+	// the token is in the url path or query.
+	InURL bool
+	// the request signature was verified by signatureMiddleware.
+	Signed bool
+	// source ip of the sender, checked against the channel allowlist.
+	// empty for ingresses without a sender address (mqtt, syslog rules), which are not checked.
+	ClientIP string
+	// the channel already read by signatureMiddleware, nil to read it.
+	Channel *d.ChannelData
 }
 
-func botMessageProcess(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	if isDebug {
-		log.Printf("botMessageProcess: %#v", message)
+// sendToChannel checks the channel token and pushes message to the channel owner and all followers.
+// it returns the number of users the message was sent to.
+func (a *App) sendToChannel(ctx context.Context, auth sendAuth, msg *channelMessage) (int, error) {
+	if msg.Text == "" && len(msg.Attachments) == 0 {
+		return 0, errors.New("wrong params")
 	}
-	if !message.IsCommand() {
-		bot.Send(buildBotResponse(message, "I can only process command now."))
+	ch, channelInfo, tokenName, err := a.authorizeSend(ctx, auth)
+	if err != nil {
+		return 0, err
+	}
+	return a.sendAuthorized(ch, channelInfo, tokenName, msg)
+}
+
+// authorizeSend loads the channel and checks the credential of a send request, it returns the token name.
+func (a *App) authorizeSend(ctx context.Context, auth sendAuth) (*d.Channel, *d.ChannelData, string, error) {
+	if auth.ChannelID == "" || auth.Token == "" {
+		return nil, nil, "", errors.New("wrong params")
+	}
+	ch := a.store.WithContext(ctx)
+	channelInfo := auth.Channel
+	if channelInfo == nil || channelInfo.ID != auth.ChannelID {
+		var err error
+		if channelInfo, err = ch.Get(auth.ChannelID); err != nil {
+			a.logger.Println("fetch channel info failed:", err)
+			return nil, nil, "", errors.New("fetch channel info failed with error")
+		}
+	}
+	if channelInfo == nil {
+		return nil, nil, "", errNoChannel
+	}
+	tokenName, ok := channelInfo.Authorize(auth.Token, d.ScopeSend, time.Now())
+	if !ok {
+		return nil, nil, "", errNoChannel
+	}
+	if auth.InURL && channelInfo.DisableURLToken {
+		return nil, nil, "", errURLTokenDisabled
+	}
+	if err := checkSigned(channelInfo, auth.Signed); err != nil {
+		return nil, nil, "", err
+	}
+	if auth.ClientIP != "" {
+		if err := a.checkSenderIP(channelInfo, tokenName, auth.ClientIP); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	a.recordTokenUse(ch, channelInfo, tokenName, auth.Token)
+	return ch, channelInfo, tokenName, nil
+}
+
+// reserveSendKey reserves the idempotency key in the db before the send, so a retry to another instance
+// or during a slow delivery is not sent twice. it returns true with the first result for a used key,
+// the result is empty while the first send is delivering.
+func (a *App) reserveSendKey(ch *d.Channel, key string) (string, bool, error) {
+	// the local cache saves the db call for retries to the same instance.
+	if result, ok := a.sendResults.get(key); ok {
+		return result, true, nil
+	}
+	result, first, err := ch.ReserveSendKey(key, sendResultTTL, time.Now())
+	if err != nil {
+		a.logger.Println("reserve idempotency key failed: ", err)
+		return "", false, errors.New("check idempotency key failed")
+	}
+	if !first && result != "" {
+		a.sendResults.set(key, result)
+	}
+	return result, !first, nil
+}
+
+// finishSendKey saves the result of the send, an empty result releases the key so the send can be retried.
+// the request may be canceled by now, the db calls don't use its context.
+func (a *App) finishSendKey(key, result string) {
+	if result == "" {
+		if err := a.store.ReleaseSendKey(key); err != nil {
+			a.logger.Println("release idempotency key failed: ", err)
+		}
 		return
 	}
+	a.sendResults.set(key, result)
+	if err := a.store.SaveSendResult(key, result); err != nil {
+		a.logger.Println("save idempotency result failed: ", err)
+	}
+}
+
+// sendAuthorized checks the rate limits and pushes message to the channel, the sender is authorized.
+func (a *App) sendAuthorized(ch *d.Channel, channelInfo *d.ChannelData, tokenName string, msg *channelMessage) (int, error) {
+	if err := a.checkSendLimit(ch, channelInfo, tokenName, time.Now()); err != nil {
+		return 0, err
+	}
+	return a.deliverToChannel(channelInfo, msg), nil
+}
+
+// deliverToChannel pushes message to the channel owner and all followers without any check.
+func (a *App) deliverToChannel(channelInfo *d.ChannelData, msg *channelMessage) int {
+	receivers := append([]int64{channelInfo.Owner}, channelInfo.Users...)
+	message := msg.Text + "\n\nFrom " + escapeText("["+channelInfo.ID+"]", msg.ParseMode)
+	// file id of uploaded attachments, the file is only uploaded once.
+	fileIDs := make([]string, len(msg.Attachments))
+	for _, userID := range receivers {
+		a.messenger.Send(OutgoingMessage{ChatID: userID, Text: message, ParseMode: msg.ParseMode, Silent: msg.Silent})
+
+		for i, file := range msg.Attachments {
+			doc := OutgoingMedia{ChatID: userID, Kind: mediaDocument, Name: file.Name, FileID: fileIDs[i], Silent: true}
+			if doc.FileID == "" {
+				doc.Data = file.Data
+			}
+			fileID, err := a.messenger.SendMedia(doc)
+			if err != nil {
+				a.logger.Printf("send attachment %s to %d failed: %s", file.Name, userID, err)
+				continue
+			}
+			if fileID != "" {
+				fileIDs[i] = fileID
+			}
+		}
+	}
 
-	command := message.Command()
-	args := message.CommandArguments()
-
-	var response *tgbotapi.MessageConfig
-	switch command {
-	case "follow":
-		response = botCommandFollow(message, args)
-	case "unfollow":
-		response = botCommandUnfollow(message, args)
-	case "new":
-		response = botCommandNewChannel(message, args)
-	case "list":
-		response = botCommandList(message, args)
-	case "token":
-		response = botCommandToken(message, args)
-	case "myid":
-		response = buildBotResponse(message, fmt.Sprintf("%d", message.Chat.ID))
-	case "channel_users":
-		response = botCommandChannelUsers(message, args)
-	case "channel_kick":
-		response = botCommandChannelKick(message, args)
+	return len(receivers)
+}
+
+// readSendRequest reads the message of POST /send.
+// the body is the message text, or multipart/form-data with a "message" field and "file" fields.
+// X-Format (markdown or html) sets the parse mode and X-Silent disables notification.
+func readSendRequest(c *gin.Context) (*channelMessage, error) {
+	msg := &channelMessage{}
+	switch strings.ToLower(c.GetHeader("X-Format")) {
+	case "", "text":
+	case "markdown":
+		msg.ParseMode = tgbotapi.ModeMarkdown
+	case "html":
+		msg.ParseMode = tgbotapi.ModeHTML
 	default:
-		bot.Send(buildBotResponse(message, "command not defined"))
+		return nil, errors.New("X-Format should be text, markdown or html")
+	}
+	msg.Silent, _ = strconv.ParseBool(c.GetHeader("X-Silent"))
+
+	if c.ContentType() == "multipart/form-data" {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return nil, errors.New("read multipart form failed.")
+		}
+		msg.Text = strings.Join(form.Value["message"], "\n")
+		for _, header := range form.File["file"] {
+			f, err := header.Open()
+			if err != nil {
+				return nil, errors.New("read attachment failed.")
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, errors.New("read attachment failed.")
+			}
+			msg.Attachments = append(msg.Attachments, attachment{Name: header.Filename, Data: data})
+		}
+		if msg.Text == "" && len(msg.Attachments) == 0 {
+			return nil, errors.New("message or file required.")
+		}
+		return msg, nil
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, errors.New("read request body failed.")
+	}
+	if len(body) < 1 {
+		return nil, errors.New("request body required.")
+	}
+	msg.Text = decodeMessage(string(body))
+	return msg, nil
+}
+
+// processUpdate handles an update from the webhook or long polling, redelivered updates are skipped.
+func (a *App) processUpdate(update tgbotapi.Update) {
+	defer func() {
+		if err := recover(); err != nil {
+			a.logger.Printf("process update %d failed: %s", update.UpdateID, err)
+		}
+	}()
+	if !a.firstDelivery(update.UpdateID) {
+		a.logger.Printf("skip redelivered update %d", update.UpdateID)
 		return
 	}
-	bot.Send(response)
+	if update.Message != nil {
+		a.logger.Printf("%d[%s] %s ", update.Message.Chat.ID, update.Message.From.UserName, update.Message.Text)
+		a.botMessageProcess(update.Message)
+	}
 }
 
-func botCommandFollow(message *tgbotapi.Message, args string) *tgbotapi.MessageConfig {
-	userID := message.Chat.ID
+func (a *App) botMessageProcess(message *tgbotapi.Message) {
+	if a.cfg.Server.Debug {
+		a.logger.Printf("a.botMessageProcess: %#v", message)
+	}
+	if !message.IsCommand() {
+		a.reply(buildBotResponse(message, "I can only process command now."))
+		return
+	}
+
+	command, ok := a.findCommand(message.Command())
+	if !ok {
+		a.reply(buildBotResponse(message, "command not defined, send /help to list the commands"))
+		return
+	}
+	if command.role == roleAdmin && !a.isAdmin(message.Chat.ID) {
+		a.reply(buildBotResponse(message, "only admin can use /"+command.name))
+		return
+	}
+	a.reply(command.handle(a, message, message.CommandArguments()))
+}
+
+// reply sends the response of a command, a failed reply is only logged.
+func (a *App) reply(response *OutgoingMessage) {
+	if _, err := a.messenger.Send(*response); err != nil {
+		a.logger.Printf("reply to %d failed: %s", response.ChatID, err)
+	}
+}
+
+func (a *App) botCommandFollow(message *tgbotapi.Message, args string) *OutgoingMessage {
 	channelID := strings.TrimSpace(args)
 
 	if channelID == "" {
 		return buildBotResponse(message, "channel name can't empty")
 	}
 
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, "conect to db failed.")
-	}
-	defer ch.Close()
-
-	channelInfo, err := ch.Get(channelID)
+	channelInfo, err := a.store.Get(channelID)
 	if err != nil {
 		return buildBotResponse(message, err.Error())
 	}
@@ -298,7 +522,12 @@ func botCommandFollow(message *tgbotapi.Message, args string) *tgbotapi.MessageC
 	if channelInfo == nil {
 		return buildBotResponse(message, "channel ID not exists")
 	}
+	return a.followChannel(message, channelInfo)
+}
 
+// followChannel adds the chat to the followers and saves the channel.
+func (a *App) followChannel(message *tgbotapi.Message, channelInfo *d.ChannelData) *OutgoingMessage {
+	userID := message.Chat.ID
 	if userID == channelInfo.Owner {
 		return buildBotResponse(message, "can't follow the channel you owned")
 	}
@@ -310,15 +539,14 @@ func botCommandFollow(message *tgbotapi.Message, args string) *tgbotapi.MessageC
 	}
 
 	channelInfo.Users = append(channelInfo.Users, userID)
-	err = ch.Update(channelInfo)
-	if err != nil {
-		log.Println("update channel info failed ", err)
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
 		return buildBotResponse(message, "update failed")
 	}
 
 	return buildBotResponse(message, "followed "+channelInfo.ID)
 }
-func botCommandUnfollow(message *tgbotapi.Message, args string) *tgbotapi.MessageConfig {
+func (a *App) botCommandUnfollow(message *tgbotapi.Message, args string) *OutgoingMessage {
 	userID := message.Chat.ID
 	channelID := strings.TrimSpace(args)
 
@@ -326,14 +554,7 @@ func botCommandUnfollow(message *tgbotapi.Message, args string) *tgbotapi.Messag
 		return buildBotResponse(message, "channel name can't empty")
 	}
 
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, "conect to db failed.")
-	}
-	defer ch.Close()
-
-	channelInfo, err := ch.Get(channelID)
+	channelInfo, err := a.store.Get(channelID)
 	if err != nil {
 		return buildBotResponse(message, err.Error())
 	}
@@ -358,27 +579,20 @@ func botCommandUnfollow(message *tgbotapi.Message, args string) *tgbotapi.Messag
 		return buildBotResponse(message, "not followed")
 	}
 
-	err = ch.Update(channelInfo)
+	err = a.store.Update(channelInfo)
 	if err != nil {
-		log.Println("update channel info failed ", err)
+		a.logger.Println("update channel info failed ", err)
 		return buildBotResponse(message, "update failed")
 	}
 
 	return buildBotResponse(message, "unfollowed "+channelInfo.ID)
 }
-func botCommandList(message *tgbotapi.Message, args string) *tgbotapi.MessageConfig {
+func (a *App) botCommandList(message *tgbotapi.Message, args string) *OutgoingMessage {
 	userID := message.Chat.ID
 
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
+	list, err := a.store.GetAll()
 	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, "conect to db failed.")
-	}
-	defer ch.Close()
-
-	list, err := ch.GetAll()
-	if err != nil {
-		log.Println("Error: ", err)
+		a.logger.Println("Error: ", err)
 		return buildBotResponse(message, "fetch list error")
 	}
 
@@ -406,8 +620,8 @@ func botCommandList(message *tgbotapi.Message, args string) *tgbotapi.MessageCon
 
 	return buildBotResponse(message, strings.Join(result, "\n"))
 }
-func botCommandNewChannel(message *tgbotapi.Message, args string) (result *tgbotapi.MessageConfig) {
-	log.Printf("create new channel: User: %d args: %s", message.Chat.ID, args)
+func (a *App) botCommandNewChannel(message *tgbotapi.Message, args string) (result *OutgoingMessage) {
+	a.logger.Printf("create new channel: User: %d args: %s", message.Chat.ID, args)
 	userID := message.Chat.ID
 	channelName := strings.TrimSpace(args)
 
@@ -415,7 +629,7 @@ func botCommandNewChannel(message *tgbotapi.Message, args string) (result *tgbot
 
 	defer func() {
 		if err := recover(); err != nil {
-			log.Println("Error: ", err)
+			a.logger.Println("Error: ", err)
 			result.Text = fmt.Sprintf("Error: %s", err)
 		}
 	}()
@@ -424,35 +638,24 @@ func botCommandNewChannel(message *tgbotapi.Message, args string) (result *tgbot
 		panic("name only accept [a-zA-Z0-9_]")
 	}
 
-	if userID != adminChatID {
-		panic("only admin can create new channel")
-	}
-
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		panic("db connect err")
-	}
-	defer ch.Close()
-
+	token := generateToken()
 	data := &d.ChannelData{
 		ID:        channelName,
-		Token:     generateToken(),
 		Owner:     userID,
 		OwnerName: message.Chat.UserName,
 	}
+	data.SetToken(token)
 
-	err = ch.Create(data)
-	if err != nil {
-		log.Println("Error: ", err)
+	if err := a.store.Create(data); err != nil {
+		a.logger.Println("Error: ", err)
 		panic("create channel failed")
 	}
-	result.Text = fmt.Sprintf("create channel ok\nID: %s\ntoken: %s", data.ID, data.Token)
+	result.Text = fmt.Sprintf("create channel ok\nID: %s\ntoken: %s\n\n%s", data.ID, token, tokenShownOnce)
 	return
 }
 
-func botCommandChannelUsers(message *tgbotapi.Message, args string) (result *tgbotapi.MessageConfig) {
-	log.Printf("channel list users: User: %d args: %s\n", message.Chat.ID, args)
+func (a *App) botCommandChannelUsers(message *tgbotapi.Message, args string) (result *OutgoingMessage) {
+	a.logger.Printf("channel list users: User: %d args: %s\n", message.Chat.ID, args)
 	userID := message.Chat.ID
 	channelName := strings.TrimSpace(args)
 	result = buildBotResponse(message, "")
@@ -462,14 +665,7 @@ func botCommandChannelUsers(message *tgbotapi.Message, args string) (result *tgb
 		return
 	}
 
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, err.Error())
-	}
-	defer ch.Close()
-
-	channelInfo, err := ch.Get(channelName)
+	channelInfo, err := a.store.Get(channelName)
 	if err != nil {
 		return buildBotResponse(message, err.Error())
 	}
@@ -491,8 +687,8 @@ func botCommandChannelUsers(message *tgbotapi.Message, args string) (result *tgb
 	return
 }
 
-func botCommandChannelKick(message *tgbotapi.Message, args string) (result *tgbotapi.MessageConfig) {
-	log.Printf("channel kick: User: %d args: %s\n", message.Chat.ID, args)
+func (a *App) botCommandChannelKick(message *tgbotapi.Message, args string) (result *OutgoingMessage) {
+	a.logger.Printf("channel kick: User: %d args: %s\n", message.Chat.ID, args)
 	userID := message.Chat.ID
 	result = buildBotResponse(message, "")
 	params := strings.Split(strings.TrimSpace(args), " ")
@@ -508,14 +704,7 @@ func botCommandChannelKick(message *tgbotapi.Message, args string) (result *tgbo
 		return
 	}
 
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, err.Error())
-	}
-	defer ch.Close()
-
-	channelInfo, err := ch.Get(channelName)
+	channelInfo, err := a.store.Get(channelName)
 	if err != nil {
 		return buildBotResponse(message, err.Error())
 	}
@@ -541,9 +730,9 @@ func botCommandChannelKick(message *tgbotapi.Message, args string) (result *tgbo
 		result.Text = "user not found"
 		return
 	}
-	err = ch.Update(channelInfo)
+	err = a.store.Update(channelInfo)
 	if err != nil {
-		log.Println("update channel info failed ", err)
+		a.logger.Println("update channel info failed ", err)
 		return buildBotResponse(message, "update failed")
 	}
 
@@ -557,37 +746,39 @@ func botCommandChannelKick(message *tgbotapi.Message, args string) (result *tgbo
 	return
 }
 
-func botCommandToken(message *tgbotapi.Message, args string) *tgbotapi.MessageConfig {
-	log.Printf("fetch channel token: User: %d args: %s", message.Chat.ID, args)
+func (a *App) botCommandURLToken(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("channel url token: User: %d args: %s", message.Chat.ID, args)
 	userID := message.Chat.ID
-	channelName := strings.TrimSpace(args)
-
-	ch, err := d.NewChannel(context.Background(), firebaseToken)
-	if err != nil {
-		log.Println("Error: ", err)
-		return buildBotResponse(message, err.Error())
+	params := strings.Fields(args)
+	if len(params) != 2 || (params[1] != "on" && params[1] != "off") {
+		return buildBotResponse(message, "wrong params, url_token [channel_name] [on|off]")
 	}
-	defer ch.Close()
 
-	channelInfo, err := ch.Get(channelName)
+	channelInfo, err := a.store.Get(params[0])
 	if err != nil {
 		return buildBotResponse(message, err.Error())
 	}
 	if channelInfo == nil {
 		return buildBotResponse(message, "channel ID not exists")
 	}
-
 	if channelInfo.Owner != userID {
-		return buildBotResponse(message, "only owner can fetch token")
+		return buildBotResponse(message, "only owner can do this")
 	}
 
-	return buildBotResponse(message, fmt.Sprintf("token: %s", channelInfo.Token))
+	channelInfo.DisableURLToken = params[1] == "off"
+	err = a.store.Update(channelInfo)
+	if err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	if channelInfo.DisableURLToken {
+		return buildBotResponse(message, "token in url disabled, use the Authorization: Bearer header")
+	}
+	return buildBotResponse(message, "token in url enabled")
 }
 
-func buildBotResponse(message *tgbotapi.Message, reply string) *tgbotapi.MessageConfig {
-	response := tgbotapi.NewMessage(message.Chat.ID, reply)
-	response.ReplyToMessageID = message.MessageID
-	return &response
+func buildBotResponse(message *tgbotapi.Message, reply string) *OutgoingMessage {
+	return &OutgoingMessage{ChatID: message.Chat.ID, Text: reply, ReplyTo: message.MessageID}
 }
 
 func checkChannelName(name string) bool {
@@ -595,25 +786,13 @@ func checkChannelName(name string) bool {
 	return r.MatchString(name)
 }
 
-func parseFirebaseToken(base64Token string) []byte {
-	if base64Token == "" {
-		log.Fatal("firebase token empty")
-	}
-	token, err := base64.StdEncoding.DecodeString(base64Token)
-	if err != nil {
-		log.Fatalf("firebase token base64 decode err: %s", err.Error())
-	}
-	return token
-}
-
+// generateToken returns "tgm_" and 192 random bits.
 func generateToken() string {
-	rand.Seed(time.Now().UnixNano())
-	var letter = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	b := make([]rune, 12)
-	for i := range b {
-		b[i] = letter[rand.Intn(len(letter))]
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return string(b)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
 }
 
 func tokenFileContentBase64Encode(filePath string) string {
@@ -624,6 +803,17 @@ func tokenFileContentBase64Encode(filePath string) string {
 	return base64.StdEncoding.EncodeToString(data)
 }
 
+// escapeText escapes text to be appended to a message of the parse mode.
+func escapeText(text, parseMode string) string {
+	switch parseMode {
+	case tgbotapi.ModeHTML:
+		return html.EscapeString(text)
+	case tgbotapi.ModeMarkdown:
+		return markdownEscaper.Replace(text)
+	}
+	return text
+}
+
 func decodeMessage(msg string) string {
 	base64Decoded, err := base64.StdEncoding.DecodeString(msg)
 	if err != nil {
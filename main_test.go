@@ -2,7 +2,10 @@ package main
 
 import (
 	"log"
+	"strings"
 	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 func TestCheckChannelName(t *testing.T) {
@@ -24,7 +27,57 @@ func TestCheckChannelName(t *testing.T) {
 
 func TestGenerateToken(t *testing.T) {
 	str := generateToken()
-	if len(str) != 12 {
+	if len(str) != len(tokenPrefix)+32 || !strings.HasPrefix(str, tokenPrefix) {
+		t.Fail()
+	}
+	if str == generateToken() {
+		t.Fail()
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	if escapeText("[nas_alert]", tgbotapi.ModeMarkdown) != "\\[nas\\_alert]" {
+		t.Fail()
+	}
+	if escapeText("<a&b>", tgbotapi.ModeHTML) != "&lt;a&amp;b&gt;" {
+		t.Fail()
+	}
+	if escapeText("[nas_alert]", "") != "[nas_alert]" {
+		t.Fail()
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	s := &Server{apps: []*App{
+		testApp(&config{Webhook: webhookConfig{BotURI: "bot_secret_path"}}),
+		testApp(&config{Webhook: webhookConfig{BotURI: "staging_secret_path"}}),
+	}}
+	list := make(map[string]string)
+	list["/send/ch/secret/aGVsbG8="] = "/send/ch/***/aGVsbG8="
+	list["/send/ch/secret"] = "/send/ch/***"
+	list["/send/ch"] = "/send/ch"
+	list["/send"] = "/send"
+	list["/bot_secret_path"] = "/***"
+	list["/staging_secret_path"] = "/***"
+	list["/b/staging/send/ch/secret"] = "/b/staging/send/ch/***"
+	list["/b/staging/send"] = "/b/staging/send"
+	list["/gotify/message?token=ch.secret"] = "/gotify/message?token=%2A%2A%2A"
+	list["/ntfy/ch?title=hi"] = "/ntfy/ch?title=hi"
+
+	for path, expect := range list {
+		if result := s.redactURL(path); result != expect {
+			log.Printf("[%s] should %s, got %s\n", path, expect, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestReserveSendKeyLocal(t *testing.T) {
+	a := testApp(&config{})
+	a.sendResults.set("test/default/abc", "ok, send to 2 user")
+	// replayed before the db, testApp has no store.
+	if result, replay, err := a.reserveSendKey(nil, "test/default/abc"); err != nil || !replay || result != "ok, send to 2 user" {
+		log.Println(result, replay, err)
 		t.Fail()
 	}
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// handlers send through Messenger instead of *tgbotapi.BotAPI, so they can be tested with a fake
+// and the telegram client can be replaced without touching them.
+
+// Messenger is the part of the Bot API used by the handlers.
+type Messenger interface {
+	// Send sends a text message and returns its message id.
+	Send(msg OutgoingMessage) (int, error)
+	// SendMedia sends a file and returns its file id, which can be sent again without uploading.
+	SendMedia(media OutgoingMedia) (string, error)
+	// Edit replaces the text of a sent message.
+	Edit(chatID int64, messageID int, text string) error
+	Delete(chatID int64, messageID int) error
+	// AnswerCallback stops the loading indicator of an inline button, text is shown as a notification.
+	AnswerCallback(callbackID, text string) error
+	// GetChatMember returns the status of the user in the chat: creator, administrator, member, left...
+	GetChatMember(chatID int64, userID int) (string, error)
+	// SetCommands sets the command menu of the scope.
+	SetCommands(scope CommandScope, commands []MenuCommand) error
+}
+
+// OutgoingMessage is a text message to a chat.
+type OutgoingMessage struct {
+	ChatID    int64
+	Text      string
+	ParseMode string
+	// message id the message replies to, 0 for none.
+	ReplyTo int
+	Silent  bool
+}
+
+// media kinds of OutgoingMedia.
+const (
+	mediaDocument = "document"
+	mediaPhoto    = "photo"
+)
+
+// OutgoingMedia is a file to a chat, uploaded from Data or resent by FileID.
+type OutgoingMedia struct {
+	ChatID  int64
+	Kind    string
+	Name    string
+	Data    []byte
+	FileID  string
+	Caption string
+	Silent  bool
+}
+
+var errUnknownMediaKind = errors.New("unknown media kind")
+
+// telegramMessenger sends with the v4 telegram-bot-api.
+type telegramMessenger struct {
+	bot *tgbotapi.BotAPI
+}
+
+func newTelegramMessenger(bot *tgbotapi.BotAPI) *telegramMessenger {
+	return &telegramMessenger{bot: bot}
+}
+
+func (m *telegramMessenger) Send(msg OutgoingMessage) (int, error) {
+	config := tgbotapi.NewMessage(msg.ChatID, msg.Text)
+	config.ParseMode = msg.ParseMode
+	config.ReplyToMessageID = msg.ReplyTo
+	config.DisableNotification = msg.Silent
+	sent, err := m.bot.Send(config)
+	if err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+func (m *telegramMessenger) SendMedia(media OutgoingMedia) (string, error) {
+	file := tgbotapi.FileBytes{Name: media.Name, Bytes: media.Data}
+	switch media.Kind {
+	case mediaDocument:
+		var config tgbotapi.DocumentConfig
+		if media.FileID != "" {
+			config = tgbotapi.NewDocumentShare(media.ChatID, media.FileID)
+		} else {
+			config = tgbotapi.NewDocumentUpload(media.ChatID, file)
+		}
+		config.Caption = media.Caption
+		config.DisableNotification = media.Silent
+		sent, err := m.bot.Send(config)
+		if err != nil {
+			return "", err
+		}
+		if sent.Document == nil {
+			return "", nil
+		}
+		return sent.Document.FileID, nil
+	case mediaPhoto:
+		var config tgbotapi.PhotoConfig
+		if media.FileID != "" {
+			config = tgbotapi.NewPhotoShare(media.ChatID, media.FileID)
+		} else {
+			config = tgbotapi.NewPhotoUpload(media.ChatID, file)
+		}
+		config.Caption = media.Caption
+		config.DisableNotification = media.Silent
+		sent, err := m.bot.Send(config)
+		if err != nil {
+			return "", err
+		}
+		if sent.Photo == nil || len(*sent.Photo) == 0 {
+			return "", nil
+		}
+		// the sizes are ordered, the last is the original.
+		photos := *sent.Photo
+		return photos[len(photos)-1].FileID, nil
+	}
+	return "", errUnknownMediaKind
+}
+
+func (m *telegramMessenger) Edit(chatID int64, messageID int, text string) error {
+	_, err := m.bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, text))
+	return err
+}
+
+func (m *telegramMessenger) Delete(chatID int64, messageID int) error {
+	_, err := m.bot.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
+
+func (m *telegramMessenger) AnswerCallback(callbackID, text string) error {
+	_, err := m.bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackID, text))
+	return err
+}
+
+func (m *telegramMessenger) GetChatMember(chatID int64, userID int) (string, error) {
+	member, err := m.bot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return "", err
+	}
+	return member.Status, nil
+}
+
+// MenuCommand is an entry of the command menu of telegram clients.
+type MenuCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// CommandScope is the chats a command menu is shown in.
+type CommandScope struct {
+	// default, all_chat_administrators or chat.
+	Type   string `json:"type"`
+	ChatID int64  `json:"chat_id,omitempty"`
+}
+
+func (m *telegramMessenger) SetCommands(scope CommandScope, commands []MenuCommand) error {
+	params := url.Values{}
+	encoded, _ := json.Marshal(commands)
+	params.Set("commands", string(encoded))
+	encoded, _ = json.Marshal(scope)
+	params.Set("scope", string(encoded))
+	_, err := m.bot.MakeRequest("setMyCommands", params)
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// fakeMessenger records what handlers send instead of calling telegram.
+type fakeMessenger struct {
+	messages []OutgoingMessage
+	media    []OutgoingMedia
+	edits    []OutgoingMessage
+	deleted  []int
+	answers  []string
+	// commands by scope, set by SetCommands.
+	menus map[CommandScope][]MenuCommand
+	// status of GetChatMember, by user id.
+	members map[int]string
+	// returned by all calls when set.
+	err error
+}
+
+func (m *fakeMessenger) Send(msg OutgoingMessage) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.messages = append(m.messages, msg)
+	return len(m.messages), nil
+}
+
+func (m *fakeMessenger) SendMedia(media OutgoingMedia) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	m.media = append(m.media, media)
+	if media.FileID != "" {
+		return media.FileID, nil
+	}
+	return "file" + strconv.Itoa(len(m.media)), nil
+}
+
+func (m *fakeMessenger) Edit(chatID int64, messageID int, text string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.edits = append(m.edits, OutgoingMessage{ChatID: chatID, Text: text, ReplyTo: messageID})
+	return nil
+}
+
+func (m *fakeMessenger) Delete(chatID int64, messageID int) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.deleted = append(m.deleted, messageID)
+	return nil
+}
+
+func (m *fakeMessenger) AnswerCallback(callbackID, text string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.answers = append(m.answers, callbackID)
+	return nil
+}
+
+func (m *fakeMessenger) GetChatMember(chatID int64, userID int) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	if status, ok := m.members[userID]; ok {
+		return status, nil
+	}
+	return "left", nil
+}
+
+func (m *fakeMessenger) SetCommands(scope CommandScope, commands []MenuCommand) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.menus == nil {
+		m.menus = make(map[CommandScope][]MenuCommand)
+	}
+	m.menus[scope] = commands
+	return nil
+}
+
+func TestTelegramMessenger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"test","username":"test_bot"}}`))
+		case "/bot123:abc/sendMessage":
+			w.Write([]byte(`{"ok":true,"result":{"message_id":7,"chat":{"id":42},"text":"hello"}}`))
+		case "/bot123:abc/sendPhoto":
+			w.Write([]byte(`{"ok":true,"result":{"message_id":8,"chat":{"id":42},"photo":[{"file_id":"small"},{"file_id":"large"}]}}`))
+		case "/bot123:abc/getChatMember":
+			w.Write([]byte(`{"ok":true,"result":{"user":{"id":43},"status":"administrator"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	bot, err := newBotAPI("123:abc", server.URL)
+	if err != nil {
+		log.Println("create bot failed: ", err)
+		t.FailNow()
+	}
+	m := newTelegramMessenger(bot)
+	if id, err := m.Send(OutgoingMessage{ChatID: 42, Text: "hello", ReplyTo: 1}); err != nil || id != 7 {
+		log.Println("send failed: ", id, err)
+		t.Fail()
+	}
+	if fileID, err := m.SendMedia(OutgoingMedia{ChatID: 42, Kind: mediaPhoto, Name: "qr.png", Data: []byte("png")}); err != nil || fileID != "large" {
+		log.Println("send photo failed: ", fileID, err)
+		t.Fail()
+	}
+	if _, err := m.SendMedia(OutgoingMedia{ChatID: 42, Kind: "video"}); err != errUnknownMediaKind {
+		log.Println("unknown media kind should be rejected: ", err)
+		t.Fail()
+	}
+	if status, err := m.GetChatMember(42, 43); err != nil || status != "administrator" {
+		log.Println("get chat member failed: ", status, err)
+		t.Fail()
+	}
+	if err := m.Delete(42, 7); err == nil {
+		log.Println("api errors should be returned")
+		t.Fail()
+	}
+}
+
+func TestDeliverToChannel(t *testing.T) {
+	messenger := &fakeMessenger{}
+	a := testApp(&config{})
+	a.messenger = messenger
+	channelInfo := &d.ChannelData{ID: "alerts", Owner: 1, Users: []int64{2, 3}}
+	msg := &channelMessage{Text: "disk full", Silent: true, Attachments: []attachment{{Name: "df.txt", Data: []byte("100%")}}}
+
+	if sent := a.deliverToChannel(channelInfo, msg); sent != 3 || len(messenger.messages) != 3 {
+		log.Println("should send to the owner and followers: ", sent, messenger.messages)
+		t.FailNow()
+	}
+	if m := messenger.messages[2]; m.ChatID != 3 || m.Text != "disk full\n\nFrom [alerts]" || !m.Silent {
+		log.Printf("wrong message %#v", m)
+		t.Fail()
+	}
+	if len(messenger.media) != 3 || messenger.media[0].Data == nil || messenger.media[1].FileID != "file1" || messenger.media[1].Data != nil {
+		log.Printf("attachment should be uploaded once %#v", messenger.media)
+		t.Fail()
+	}
+}
+
+func TestBotMessageProcessReply(t *testing.T) {
+	messenger := &fakeMessenger{}
+	a := testApp(&config{})
+	a.messenger = messenger
+	a.botMessageProcess(&tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: 42}, Text: "hello"})
+	if len(messenger.messages) != 1 || messenger.messages[0].ChatID != 42 || messenger.messages[0].ReplyTo != 5 {
+		log.Printf("should reply to the message %#v", messenger.messages)
+		t.Fail()
+	}
+
+	messenger.err = errors.New("blocked by user")
+	a.botMessageProcess(&tgbotapi.Message{MessageID: 6, Chat: &tgbotapi.Chat{ID: 42}, Text: "hello"})
+	if len(messenger.messages) != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT bridge, payloads published to mapped topics are pushed to channels.
+
+// mqttMapping maps a topic filter to a channel.
+type mqttMapping struct {
+	Topic   string `json:"topic"`
+	Channel string `json:"channel"`
+	Token   string `json:"token"`
+	QoS     byte   `json:"qos"`
+	// optional text/template, see mqttTemplateData for the fields.
+	Template string `json:"template"`
+
+	tmpl *template.Template
+}
+
+// mqttTemplateData is the data passed to the mapping template.
+type mqttTemplateData struct {
+	Topic   string
+	Payload string
+	// topic levels matched by + and # in the filter.
+	Wildcards []string
+	// payload decoded as json, nil if the payload is not json.
+	JSON interface{}
+}
+
+func (m *mqttMapping) compile() error {
+	if m.Topic == "" || m.Channel == "" || m.Token == "" {
+		return errors.New("topic, channel and token required")
+	}
+	if m.QoS > 2 {
+		return errors.New("qos should be 0, 1 or 2")
+	}
+	if m.Template != "" {
+		tmpl, err := template.New(m.Topic).Parse(m.Template)
+		if err != nil {
+			return err
+		}
+		m.tmpl = tmpl
+	}
+	return nil
+}
+
+// render formats the payload, the default is "<topic>\n<payload>".
+func (m *mqttMapping) render(topic string, payload []byte) (string, error) {
+	if m.tmpl == nil {
+		return topic + "\n" + string(payload), nil
+	}
+	data := mqttTemplateData{Topic: topic, Payload: string(payload)}
+	data.Wildcards, _ = mqttTopicMatch(m.Topic, topic)
+	var v interface{}
+	if json.Unmarshal(payload, &v) == nil {
+		data.JSON = v
+	}
+	var s bytes.Buffer
+	if err := m.tmpl.Execute(&s, data); err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+func loadMQTTMappings(path string) ([]*mqttMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mappings := make([]*mqttMapping, 0)
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, err
+	}
+	for i, m := range mappings {
+		if err := m.compile(); err != nil {
+			return nil, fmt.Errorf("mqtt mapping %d: %s", i, err)
+		}
+	}
+	return mappings, nil
+}
+
+// mqttTopicMatch checks topic against a filter with + and # wildcards,
+// it returns the topic levels matched by the wildcards.
+func mqttTopicMatch(filter, topic string) ([]string, bool) {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	wildcards := make([]string, 0)
+	// topics starting with $ are not matched by wildcards at the first level.
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return nil, false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return append(wildcards, strings.Join(topicLevels[i:], "/")), true
+		}
+		if i >= len(topicLevels) {
+			return nil, false
+		}
+		if level == "+" {
+			wildcards = append(wildcards, topicLevels[i])
+			continue
+		}
+		if level != topicLevels[i] {
+			return nil, false
+		}
+	}
+	if len(filterLevels) != len(topicLevels) {
+		return nil, false
+	}
+	return wildcards, true
+}
+
+// mqttBridge routes received messages to the mappings of the subscription.
+type mqttBridge struct {
+	mappings []*mqttMapping
+	send     func(m *mqttMapping, text string)
+	// the logger of the app, also used by the client callbacks.
+	logger *log.Logger
+}
+
+// filters returns the distinct topic filters with the highest qos of their mappings.
+func (b *mqttBridge) filters() map[string]byte {
+	filters := make(map[string]byte)
+	for _, m := range b.mappings {
+		if qos, ok := filters[m.Topic]; !ok || m.QoS > qos {
+			filters[m.Topic] = m.QoS
+		}
+	}
+	return filters
+}
+
+// handle is called once per subscription filter the message matched.
+func (b *mqttBridge) handle(filter, topic string, payload []byte) {
+	for _, m := range b.mappings {
+		if m.Topic != filter {
+			continue
+		}
+		text, err := m.render(topic, payload)
+		if err != nil {
+			b.logger.Printf("mqtt render %s failed: %s", topic, err)
+			continue
+		}
+		b.send(m, text)
+	}
+}
+
+// newChannelMQTTBridge creates a bridge sending to the channels of the mappings.
+func (a *App) newChannelMQTTBridge(mappings []*mqttMapping) *mqttBridge {
+	return &mqttBridge{
+		mappings: mappings,
+		send: func(m *mqttMapping, text string) {
+			_, err := a.sendToChannel(context.Background(), sendAuth{ChannelID: m.Channel, Token: m.Token}, &channelMessage{Text: text})
+			if err != nil {
+				a.logger.Printf("mqtt send to %s failed: %s", m.Channel, err)
+			}
+		},
+		logger: a.logger,
+	}
+}
+
+// startMQTTBridge connects to the broker and subscribes the mapped topics,
+// subscriptions are restored on reconnect, the connection is logged to the logger of the bridge.
+func startMQTTBridge(broker, clientID, username, password string, bridge *mqttBridge) (mqtt.Client, error) {
+	if clientID == "" {
+		clientID = fmt.Sprintf("telegram-messager-%d", time.Now().Unix())
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		bridge.logger.Printf("mqtt connected: %s", broker)
+		for filter, qos := range bridge.filters() {
+			filter := filter
+			token := c.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
+				bridge.handle(filter, msg.Topic(), msg.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				bridge.logger.Printf("mqtt subscribe %s failed: %s", filter, token.Error())
+			}
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		bridge.logger.Println("mqtt connection lost: ", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.WaitTimeout(30*time.Second) && token.Error() != nil {
+		return nil, token.Error()
+	}
+	return client, nil
+}
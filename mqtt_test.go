@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTTTopicMatch(t *testing.T) {
+	type c struct {
+		filter, topic string
+		ok            bool
+		wildcards     string
+	}
+	list := []c{
+		{"sensors/+/temperature", "sensors/kitchen/temperature", true, "kitchen"},
+		{"sensors/+/temperature", "sensors/kitchen/humidity", false, ""},
+		{"sensors/#", "sensors/kitchen/temperature", true, "kitchen/temperature"},
+		{"sensors/#", "sensors", true, ""},
+		{"+/+", "a/b", true, "a,b"},
+		{"a/b", "a/b/c", false, ""},
+		{"a/b/c", "a/b", false, ""},
+		{"#", "$SYS/broker", false, ""},
+	}
+	for _, item := range list {
+		wildcards, ok := mqttTopicMatch(item.filter, item.topic)
+		if ok != item.ok || (ok && strings.Join(wildcards, ",") != item.wildcards) {
+			log.Printf("[%s] [%s] should %t %s, got %t %v\n", item.filter, item.topic, item.ok, item.wildcards, ok, wildcards)
+			t.Fail()
+		}
+	}
+}
+
+func TestMQTTMappingRender(t *testing.T) {
+	m := &mqttMapping{Topic: "sensors/+/temperature", Channel: "home", Token: "t", Template: `{{index .Wildcards 0}}: {{.JSON.value}}°C`}
+	if err := m.compile(); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	text, err := m.render("sensors/kitchen/temperature", []byte(`{"value": 21.5}`))
+	if err != nil || text != "kitchen: 21.5°C" {
+		log.Println(text, err)
+		t.Fail()
+	}
+
+	m = &mqttMapping{Topic: "door", Channel: "home", Token: "t"}
+	m.compile()
+	if text, _ := m.render("door", []byte("open")); text != "door\nopen" {
+		t.Fail()
+	}
+
+	if err := (&mqttMapping{Topic: "a", Channel: "home", Token: "t", Template: "{{"}).compile(); err == nil {
+		t.Fail()
+	}
+}
+
+// TestMQTTBridgeBroker runs against a local broker, e.g. MQTT_TEST_BROKER=tcp://127.0.0.1:1883
+func TestMQTTBridgeBroker(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER not set")
+	}
+	m := &mqttMapping{Topic: "telegram-messager-test/+", Channel: "home", Token: "t"}
+	m.compile()
+	received := make(chan string, 1)
+	bridge := &mqttBridge{
+		mappings: []*mqttMapping{m},
+		send: func(m *mqttMapping, text string) {
+			received <- text
+		},
+		logger: log.Default(),
+	}
+	client, err := startMQTTBridge(broker, "", "", "", bridge)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	defer client.Disconnect(100)
+
+	// wait for the subscription
+	time.Sleep(500 * time.Millisecond)
+	client.Publish("telegram-messager-test/door", 0, false, "open").Wait()
+	select {
+	case text := <-received:
+		if text != "telegram-messager-test/door\nopen" {
+			log.Println(text)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		log.Println("message not received")
+		t.Fail()
+	}
+}
@@ -0,0 +1,614 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: messager.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MessagerClient is the client API for Messager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MessagerClient interface {
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error)
+	// SendMessages sends a stream of messages, the result is returned when the stream ends.
+	SendMessages(ctx context.Context, opts ...grpc.CallOption) (Messager_SendMessagesClient, error)
+	CreateChannel(ctx context.Context, in *CreateChannelRequest, opts ...grpc.CallOption) (*Channel, error)
+	GetChannel(ctx context.Context, in *GetChannelRequest, opts ...grpc.CallOption) (*Channel, error)
+	ListChannels(ctx context.Context, in *ListChannelsRequest, opts ...grpc.CallOption) (*ListChannelsResponse, error)
+	DeleteChannel(ctx context.Context, in *DeleteChannelRequest, opts ...grpc.CallOption) (*DeleteChannelResponse, error)
+	ListSubscribers(ctx context.Context, in *ListSubscribersRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error)
+	AddSubscriber(ctx context.Context, in *SubscriberRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error)
+	RemoveSubscriber(ctx context.Context, in *SubscriberRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error)
+	// token management requires an admin key, the token name "default" is the channel token.
+	CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*TokenSecret, error)
+	RotateToken(ctx context.Context, in *RotateTokenRequest, opts ...grpc.CallOption) (*TokenSecret, error)
+	ListTokens(ctx context.Context, in *ListTokensRequest, opts ...grpc.CallOption) (*ListTokensResponse, error)
+	RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error)
+	// replaces the sender allowlist of the channel, requires an admin key.
+	SetAllowedIPs(ctx context.Context, in *SetAllowedIPsRequest, opts ...grpc.CallOption) (*Channel, error)
+}
+
+type messagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessagerClient(cc grpc.ClientConnInterface) MessagerClient {
+	return &messagerClient{cc}
+}
+
+func (c *messagerClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error) {
+	out := new(SendMessageResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/SendMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) SendMessages(ctx context.Context, opts ...grpc.CallOption) (Messager_SendMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Messager_ServiceDesc.Streams[0], "/messager.v1.Messager/SendMessages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messagerSendMessagesClient{stream}
+	return x, nil
+}
+
+type Messager_SendMessagesClient interface {
+	Send(*SendMessageRequest) error
+	CloseAndRecv() (*SendMessagesResponse, error)
+	grpc.ClientStream
+}
+
+type messagerSendMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messagerSendMessagesClient) Send(m *SendMessageRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *messagerSendMessagesClient) CloseAndRecv() (*SendMessagesResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(SendMessagesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messagerClient) CreateChannel(ctx context.Context, in *CreateChannelRequest, opts ...grpc.CallOption) (*Channel, error) {
+	out := new(Channel)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/CreateChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) GetChannel(ctx context.Context, in *GetChannelRequest, opts ...grpc.CallOption) (*Channel, error) {
+	out := new(Channel)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/GetChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) ListChannels(ctx context.Context, in *ListChannelsRequest, opts ...grpc.CallOption) (*ListChannelsResponse, error) {
+	out := new(ListChannelsResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/ListChannels", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) DeleteChannel(ctx context.Context, in *DeleteChannelRequest, opts ...grpc.CallOption) (*DeleteChannelResponse, error) {
+	out := new(DeleteChannelResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/DeleteChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) ListSubscribers(ctx context.Context, in *ListSubscribersRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error) {
+	out := new(ListSubscribersResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/ListSubscribers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) AddSubscriber(ctx context.Context, in *SubscriberRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error) {
+	out := new(ListSubscribersResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/AddSubscriber", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) RemoveSubscriber(ctx context.Context, in *SubscriberRequest, opts ...grpc.CallOption) (*ListSubscribersResponse, error) {
+	out := new(ListSubscribersResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/RemoveSubscriber", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*TokenSecret, error) {
+	out := new(TokenSecret)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/CreateToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) RotateToken(ctx context.Context, in *RotateTokenRequest, opts ...grpc.CallOption) (*TokenSecret, error) {
+	out := new(TokenSecret)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/RotateToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) ListTokens(ctx context.Context, in *ListTokensRequest, opts ...grpc.CallOption) (*ListTokensResponse, error) {
+	out := new(ListTokensResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/ListTokens", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error) {
+	out := new(RevokeTokenResponse)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/RevokeToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagerClient) SetAllowedIPs(ctx context.Context, in *SetAllowedIPsRequest, opts ...grpc.CallOption) (*Channel, error) {
+	out := new(Channel)
+	err := c.cc.Invoke(ctx, "/messager.v1.Messager/SetAllowedIPs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MessagerServer is the server API for Messager service.
+// All implementations must embed UnimplementedMessagerServer
+// for forward compatibility
+type MessagerServer interface {
+	SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error)
+	// SendMessages sends a stream of messages, the result is returned when the stream ends.
+	SendMessages(Messager_SendMessagesServer) error
+	CreateChannel(context.Context, *CreateChannelRequest) (*Channel, error)
+	GetChannel(context.Context, *GetChannelRequest) (*Channel, error)
+	ListChannels(context.Context, *ListChannelsRequest) (*ListChannelsResponse, error)
+	DeleteChannel(context.Context, *DeleteChannelRequest) (*DeleteChannelResponse, error)
+	ListSubscribers(context.Context, *ListSubscribersRequest) (*ListSubscribersResponse, error)
+	AddSubscriber(context.Context, *SubscriberRequest) (*ListSubscribersResponse, error)
+	RemoveSubscriber(context.Context, *SubscriberRequest) (*ListSubscribersResponse, error)
+	// token management requires an admin key, the token name "default" is the channel token.
+	CreateToken(context.Context, *CreateTokenRequest) (*TokenSecret, error)
+	RotateToken(context.Context, *RotateTokenRequest) (*TokenSecret, error)
+	ListTokens(context.Context, *ListTokensRequest) (*ListTokensResponse, error)
+	RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error)
+	// replaces the sender allowlist of the channel, requires an admin key.
+	SetAllowedIPs(context.Context, *SetAllowedIPsRequest) (*Channel, error)
+	mustEmbedUnimplementedMessagerServer()
+}
+
+// UnimplementedMessagerServer must be embedded to have forward compatible implementations.
+type UnimplementedMessagerServer struct {
+}
+
+func (UnimplementedMessagerServer) SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedMessagerServer) SendMessages(Messager_SendMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SendMessages not implemented")
+}
+func (UnimplementedMessagerServer) CreateChannel(context.Context, *CreateChannelRequest) (*Channel, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateChannel not implemented")
+}
+func (UnimplementedMessagerServer) GetChannel(context.Context, *GetChannelRequest) (*Channel, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannel not implemented")
+}
+func (UnimplementedMessagerServer) ListChannels(context.Context, *ListChannelsRequest) (*ListChannelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChannels not implemented")
+}
+func (UnimplementedMessagerServer) DeleteChannel(context.Context, *DeleteChannelRequest) (*DeleteChannelResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteChannel not implemented")
+}
+func (UnimplementedMessagerServer) ListSubscribers(context.Context, *ListSubscribersRequest) (*ListSubscribersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSubscribers not implemented")
+}
+func (UnimplementedMessagerServer) AddSubscriber(context.Context, *SubscriberRequest) (*ListSubscribersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddSubscriber not implemented")
+}
+func (UnimplementedMessagerServer) RemoveSubscriber(context.Context, *SubscriberRequest) (*ListSubscribersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveSubscriber not implemented")
+}
+func (UnimplementedMessagerServer) CreateToken(context.Context, *CreateTokenRequest) (*TokenSecret, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToken not implemented")
+}
+func (UnimplementedMessagerServer) RotateToken(context.Context, *RotateTokenRequest) (*TokenSecret, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateToken not implemented")
+}
+func (UnimplementedMessagerServer) ListTokens(context.Context, *ListTokensRequest) (*ListTokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTokens not implemented")
+}
+func (UnimplementedMessagerServer) RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeToken not implemented")
+}
+func (UnimplementedMessagerServer) SetAllowedIPs(context.Context, *SetAllowedIPsRequest) (*Channel, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetAllowedIPs not implemented")
+}
+func (UnimplementedMessagerServer) mustEmbedUnimplementedMessagerServer() {}
+
+// UnsafeMessagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessagerServer will
+// result in compilation errors.
+type UnsafeMessagerServer interface {
+	mustEmbedUnimplementedMessagerServer()
+}
+
+func RegisterMessagerServer(s grpc.ServiceRegistrar, srv MessagerServer) {
+	s.RegisterService(&Messager_ServiceDesc, srv)
+}
+
+func _Messager_SendMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).SendMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/SendMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).SendMessage(ctx, req.(*SendMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_SendMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MessagerServer).SendMessages(&messagerSendMessagesServer{stream})
+}
+
+type Messager_SendMessagesServer interface {
+	SendAndClose(*SendMessagesResponse) error
+	Recv() (*SendMessageRequest, error)
+	grpc.ServerStream
+}
+
+type messagerSendMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messagerSendMessagesServer) SendAndClose(m *SendMessagesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *messagerSendMessagesServer) Recv() (*SendMessageRequest, error) {
+	m := new(SendMessageRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Messager_CreateChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).CreateChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/CreateChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).CreateChannel(ctx, req.(*CreateChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_GetChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).GetChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/GetChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).GetChannel(ctx, req.(*GetChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_ListChannels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChannelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).ListChannels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/ListChannels",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).ListChannels(ctx, req.(*ListChannelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_DeleteChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).DeleteChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/DeleteChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).DeleteChannel(ctx, req.(*DeleteChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_ListSubscribers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSubscribersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).ListSubscribers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/ListSubscribers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).ListSubscribers(ctx, req.(*ListSubscribersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_AddSubscriber_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscriberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).AddSubscriber(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/AddSubscriber",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).AddSubscriber(ctx, req.(*SubscriberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_RemoveSubscriber_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscriberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).RemoveSubscriber(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/RemoveSubscriber",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).RemoveSubscriber(ctx, req.(*SubscriberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_CreateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).CreateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/CreateToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).CreateToken(ctx, req.(*CreateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_RotateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).RotateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/RotateToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).RotateToken(ctx, req.(*RotateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_ListTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).ListTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/ListTokens",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).ListTokens(ctx, req.(*ListTokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_RevokeToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).RevokeToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/RevokeToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).RevokeToken(ctx, req.(*RevokeTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messager_SetAllowedIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetAllowedIPsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagerServer).SetAllowedIPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messager.v1.Messager/SetAllowedIPs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagerServer).SetAllowedIPs(ctx, req.(*SetAllowedIPsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Messager_ServiceDesc is the grpc.ServiceDesc for Messager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Messager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "messager.v1.Messager",
+	HandlerType: (*MessagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendMessage",
+			Handler:    _Messager_SendMessage_Handler,
+		},
+		{
+			MethodName: "CreateChannel",
+			Handler:    _Messager_CreateChannel_Handler,
+		},
+		{
+			MethodName: "GetChannel",
+			Handler:    _Messager_GetChannel_Handler,
+		},
+		{
+			MethodName: "ListChannels",
+			Handler:    _Messager_ListChannels_Handler,
+		},
+		{
+			MethodName: "DeleteChannel",
+			Handler:    _Messager_DeleteChannel_Handler,
+		},
+		{
+			MethodName: "ListSubscribers",
+			Handler:    _Messager_ListSubscribers_Handler,
+		},
+		{
+			MethodName: "AddSubscriber",
+			Handler:    _Messager_AddSubscriber_Handler,
+		},
+		{
+			MethodName: "RemoveSubscriber",
+			Handler:    _Messager_RemoveSubscriber_Handler,
+		},
+		{
+			MethodName: "CreateToken",
+			Handler:    _Messager_CreateToken_Handler,
+		},
+		{
+			MethodName: "RotateToken",
+			Handler:    _Messager_RotateToken_Handler,
+		},
+		{
+			MethodName: "ListTokens",
+			Handler:    _Messager_ListTokens_Handler,
+		},
+		{
+			MethodName: "RevokeToken",
+			Handler:    _Messager_RevokeToken_Handler,
+		},
+		{
+			MethodName: "SetAllowedIPs",
+			Handler:    _Messager_SetAllowedIPs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendMessages",
+			Handler:       _Messager_SendMessages_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "messager.proto",
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// long polling mode, for installs without a public url.
+
+const (
+	// seconds telegram holds a getUpdates request open.
+	pollTimeout = 60
+	// wait after a failed getUpdates.
+	pollRetryInterval = 3 * time.Second
+)
+
+// botUpdates returns the getUpdates call of the bot.
+func botUpdates(bot *tgbotapi.BotAPI) func(offset int) ([]tgbotapi.Update, error) {
+	return func(offset int) ([]tgbotapi.Update, error) {
+		return bot.GetUpdates(tgbotapi.UpdateConfig{Offset: offset, Timeout: pollTimeout})
+	}
+}
+
+// pollUpdates passes updates to handle until ctx is done.
+// the offset confirms the handled updates, so telegram doesn't send them again.
+func (a *App) pollUpdates(ctx context.Context, getUpdates func(offset int) ([]tgbotapi.Update, error), handle func(tgbotapi.Update)) {
+	a.logger.Println("polling telegram updates")
+	offset := 0
+	for ctx.Err() == nil {
+		updates, err := getUpdates(offset)
+		if err != nil {
+			a.logger.Println("get updates failed: ", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollRetryInterval):
+			}
+			continue
+		}
+		for _, update := range updates {
+			if update.UpdateID < offset {
+				continue
+			}
+			offset = update.UpdateID + 1
+			handle(update)
+		}
+	}
+}
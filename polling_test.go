@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestPollUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	offsets := make([]int, 0)
+	responses := [][]tgbotapi.Update{
+		{{UpdateID: 10}, {UpdateID: 11}},
+		nil,
+		// an update before the offset is not handled again.
+		{{UpdateID: 11}, {UpdateID: 12}},
+	}
+	getUpdates := func(offset int) ([]tgbotapi.Update, error) {
+		offsets = append(offsets, offset)
+		if len(responses) == 0 {
+			cancel()
+			return nil, errors.New("done")
+		}
+		updates := responses[0]
+		responses = responses[1:]
+		return updates, nil
+	}
+	handled := make([]int, 0)
+	testApp(&config{}).pollUpdates(ctx, getUpdates, func(update tgbotapi.Update) {
+		handled = append(handled, update.UpdateID)
+	})
+
+	if len(handled) != 3 || handled[0] != 10 || handled[2] != 12 {
+		log.Println("handled: ", handled)
+		t.Fail()
+	}
+	if len(offsets) != 4 || offsets[0] != 0 || offsets[1] != 12 || offsets[2] != 12 || offsets[3] != 13 {
+		log.Println("offsets: ", offsets)
+		t.Fail()
+	}
+}
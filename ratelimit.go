@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// per channel and per token rate limits, the counters are stored in the db.
+
+// rateLimitError is returned by sendToChannel when a limit or quota is exceeded.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %d seconds", e.retryAfterSeconds())
+}
+
+func (e *rateLimitError) retryAfterSeconds() int {
+	seconds := int((e.retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// checkSendLimit takes one message from the channel limit and the token limit.
+// tokenName is empty for admin callers, only the channel limit applies.
+// the send is allowed when the counters can't be updated.
+func (a *App) checkSendLimit(ch *d.Channel, channelInfo *d.ChannelData, tokenName string, now time.Time) error {
+	keys := make([]string, 0, 2)
+	limits := make([]d.RateLimit, 0, 2)
+	limit := channelInfo.RateLimit
+	if limit.IsZero() {
+		limit = a.channelRateLimit
+	}
+	if !limit.IsZero() {
+		keys = append(keys, channelInfo.ID)
+		limits = append(limits, limit)
+	}
+	if tokenName != "" {
+		if limit := channelInfo.TokenRateLimit(tokenName); !limit.IsZero() {
+			keys = append(keys, channelInfo.ID+":"+tokenName)
+			limits = append(limits, limit)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	allowed, retryAfter, err := ch.TakeLimits(keys, limits, now)
+	if err != nil {
+		a.logger.Printf("channel %s: rate limit check failed: %s", channelInfo.ID, err)
+		return nil
+	}
+	if !allowed {
+		a.logger.Printf("channel %s: rate limited, token %s", channelInfo.ID, tokenName)
+		return &rateLimitError{retryAfter: retryAfter}
+	}
+	return nil
+}
+
+// writeSendError writes the error of sendToChannel, rate limited requests get 429 with Retry-After.
+func writeSendError(c *gin.Context, err error) {
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		c.Header("Retry-After", strconv.Itoa(limitErr.retryAfterSeconds()))
+		c.String(http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err == errSignatureRequired {
+		c.String(http.StatusUnauthorized, err.Error())
+		return
+	}
+	c.String(http.StatusBadRequest, err.Error())
+}
+
+// parseRateLimit parses "minute=30 burst=10 day=1000", "off" is no limit.
+func parseRateLimit(fields []string) (d.RateLimit, error) {
+	var limit d.RateLimit
+	if len(fields) == 1 && fields[0] == "off" {
+		return limit, nil
+	}
+	if len(fields) == 0 {
+		return limit, errors.New("limit required, e.g. minute=30 burst=10 day=1000 or off")
+	}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return limit, fmt.Errorf("wrong limit %s", field)
+		}
+		switch key {
+		case "minute":
+			limit.PerMinute = n
+		case "burst":
+			limit.Burst = n
+		case "day":
+			limit.Daily = n
+		default:
+			return limit, fmt.Errorf("unknown limit %s, use minute, burst or day", key)
+		}
+	}
+	if limit.Burst > 0 && limit.PerMinute == 0 {
+		return limit, errors.New("burst requires minute")
+	}
+	return limit, nil
+}
+
+func formatRateLimit(limit d.RateLimit) string {
+	if limit.IsZero() {
+		return "unlimited"
+	}
+	parts := make([]string, 0, 3)
+	if limit.PerMinute > 0 {
+		parts = append(parts, fmt.Sprintf("%d/minute", limit.PerMinute))
+	}
+	if limit.Burst > 0 {
+		parts = append(parts, fmt.Sprintf("burst %d", limit.Burst))
+	}
+	if limit.Daily > 0 {
+		parts = append(parts, fmt.Sprintf("%d/day", limit.Daily))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (a *App) botCommandRateLimit(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("channel rate limit: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) < 1 {
+		return buildBotResponse(message, "wrong params, rate_limit [channel_name] [token=name] [minute=N burst=N day=N|off]")
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	params = params[1:]
+	tokenName := ""
+	if len(params) > 0 && strings.HasPrefix(params[0], "token=") {
+		tokenName = strings.TrimPrefix(params[0], "token=")
+		if tokenName != d.DefaultTokenName && channelInfo.NamedToken(tokenName) == nil {
+			return buildBotResponse(message, "token not found")
+		}
+		params = params[1:]
+	}
+
+	if len(params) > 0 {
+		limit, err := parseRateLimit(params)
+		if err != nil {
+			return buildBotResponse(message, err.Error())
+		}
+		if tokenName == "" {
+			channelInfo.RateLimit = limit
+		} else {
+			channelInfo.SetTokenRateLimit(tokenName, limit)
+		}
+		if err := a.store.Update(channelInfo); err != nil {
+			a.logger.Println("update channel info failed ", err)
+			return buildBotResponse(message, "update failed")
+		}
+	}
+
+	if tokenName != "" {
+		return buildBotResponse(message, "token "+tokenName+" limit: "+formatRateLimit(channelInfo.TokenRateLimit(tokenName)))
+	}
+	reply := "channel limit: " + formatRateLimit(channelInfo.RateLimit)
+	if channelInfo.RateLimit.IsZero() && !a.channelRateLimit.IsZero() {
+		reply = "channel limit: server default " + formatRateLimit(a.channelRateLimit)
+	}
+	reply += "\n" + d.DefaultTokenName + ": " + formatRateLimit(channelInfo.DefaultTokenLimit)
+	for _, t := range channelInfo.Tokens {
+		reply += "\n" + t.Name + ": " + formatRateLimit(t.RateLimit)
+	}
+	return buildBotResponse(message, reply)
+}
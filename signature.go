@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// signed send requests, enabled per channel with /signing.
+//
+// the client sends X-Signature-Timestamp (unix seconds), X-Signature-Nonce and
+// X-Signature: hex(hmac-sha256(secret, method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + body)).
+
+const (
+	// gin context key set by signatureMiddleware when the request signature was verified.
+	signedContextKey = "signed"
+	// gin context key of the channel loaded by signatureMiddleware, so the send doesn't read it again.
+	channelContextKey = "channel"
+	// requests with a timestamp further away are rejected.
+	signatureMaxSkew = 5 * time.Minute
+	// body size limit of signed requests, the telegram upload limit.
+	maxSignedBodySize = 50 << 20
+)
+
+var (
+	errSignatureRequired = errors.New("signature required")
+	errSignatureInvalid  = errors.New("signature not match")
+	errSignatureStale    = errors.New("signature timestamp too old or in the future")
+	errSignatureReplayed = errors.New("signature nonce already used")
+	// the nonce db failed.
+	errSignatureNonceCheck = errors.New("check signature nonce failed")
+)
+
+// signRequest returns the hex signature of the request.
+func signRequest(secret, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the signature and the timestamp, the nonce is checked by the caller.
+func verifySignature(secret, method, path, timestamp, nonce string, body []byte, signature string, now time.Time) error {
+	if timestamp == "" || nonce == "" || signature == "" {
+		return errSignatureRequired
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errSignatureStale
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return errSignatureStale
+	}
+	expected := signRequest(secret, method, path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return errSignatureInvalid
+	}
+	return nil
+}
+
+// signatureMiddleware verifies the signature of send requests to channels with a signing secret.
+// requests to other channels pass through unchanged.
+func (a *App) signatureMiddleware(c *gin.Context) {
+	channelID := c.Param("name")
+	if channelID == "" {
+		channelID = c.GetHeader("X-ChannelName")
+	}
+	if channelID == "" {
+		c.Next()
+		return
+	}
+	channelInfo, err := a.store.WithContext(c.Request.Context()).Get(channelID)
+	if err != nil {
+		a.logger.Println("fetch channel info failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if channelInfo == nil {
+		c.Next()
+		return
+	}
+	c.Set(channelContextKey, channelInfo)
+	secret := channelInfo.SigningSecret
+	if secret == "" {
+		c.Next()
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBodySize))
+	if err != nil {
+		c.String(http.StatusRequestEntityTooLarge, "read request body failed.")
+		c.Abort()
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	nonce := c.GetHeader("X-Signature-Nonce")
+	err = verifySignature(secret, c.Request.Method, c.Request.URL.Path, c.GetHeader("X-Signature-Timestamp"),
+		nonce, body, c.GetHeader("X-Signature"), time.Now())
+	if err == nil {
+		err = a.useNonce(c.Request.Context(), channelID, nonce)
+	}
+	if err == errSignatureNonceCheck {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusUnauthorized, err.Error())
+		c.Abort()
+		return
+	}
+	c.Set(signedContextKey, true)
+	c.Next()
+}
+
+// useNonce rejects a nonce used before, the local cache saves the db call for replays to the same instance.
+func (a *App) useNonce(ctx context.Context, channelID, nonce string) error {
+	if !a.signatureNonces.add(channelID+"/"+nonce, "") {
+		return errSignatureReplayed
+	}
+	first, err := a.store.WithContext(ctx).MarkNonce(channelID, nonce, 2*signatureMaxSkew, time.Now())
+	if err != nil {
+		// a replay can't be ruled out.
+		a.logger.Println("mark signature nonce failed: ", err)
+		return errSignatureNonceCheck
+	}
+	if !first {
+		return errSignatureReplayed
+	}
+	return nil
+}
+
+// checkSigned rejects unsigned sends to a channel with a signing secret.
+// the middleware only runs on the /send routes, other ingresses can't sign and are always rejected.
+func checkSigned(channelInfo *d.ChannelData, signed bool) error {
+	if channelInfo.SigningSecret != "" && !signed {
+		return errSignatureRequired
+	}
+	return nil
+}
+
+// loadedChannel returns the channel loaded by signatureMiddleware, nil if not loaded.
+func loadedChannel(c *gin.Context) *d.ChannelData {
+	channelInfo, _ := c.Value(channelContextKey).(*d.ChannelData)
+	return channelInfo
+}
+
+func (a *App) botCommandSigning(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("channel signing: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) != 2 || (params[1] != "on" && params[1] != "off") {
+		return buildBotResponse(message, "wrong params, signing [channel_name] [on|off]")
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	channelInfo.SigningSecret = ""
+	if params[1] == "on" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			a.logger.Println("generate signing secret failed ", err)
+			return buildBotResponse(message, "generate secret failed")
+		}
+		channelInfo.SigningSecret = hex.EncodeToString(b)
+	}
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	if channelInfo.SigningSecret == "" {
+		return buildBotResponse(message, "signing disabled")
+	}
+	return buildBotResponse(message, "signing enabled, send requests without a valid signature are rejected, also from ntfy, gotify, email, grpc, mqtt and syslog which can't sign.\nsecret: "+channelInfo.SigningSecret)
+}
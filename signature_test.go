@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	d "github.com/hitian/telegram-messager/data"
+)
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte("hello")
+	signature := "5ebd3a799a96691623f1d972476bbe803bb3b445a61d019d0ba66ee784d7a0da"
+	if s := signRequest("secret", "POST", "/send", "1700000000", "abc", body); s != signature {
+		log.Println("wrong signature: ", s)
+		t.Fail()
+	}
+
+	list := []struct {
+		method, path, timestamp, nonce, body, signature string
+		now                                             time.Time
+		err                                             error
+	}{
+		{"POST", "/send", "1700000000", "abc", "hello", signature, now, nil},
+		{"POST", "/send", "1700000000", "abc", "hello", signature, now.Add(4 * time.Minute), nil},
+		{"POST", "/send", "1700000000", "abc", "hello", signature, now.Add(6 * time.Minute), errSignatureStale},
+		{"POST", "/send", "1700000000", "abc", "hello", signature, now.Add(-6 * time.Minute), errSignatureStale},
+		{"POST", "/send", "1700000000", "abc", "hello!", signature, now, errSignatureInvalid},
+		{"POST", "/send/other", "1700000000", "abc", "hello", signature, now, errSignatureInvalid},
+		{"POST", "/send", "1700000000", "abd", "hello", signature, now, errSignatureInvalid},
+		{"POST", "/send", "1700000000", "", "hello", signature, now, errSignatureRequired},
+		{"POST", "/send", "1700000000", "abc", "hello", "", now, errSignatureRequired},
+		{"POST", "/send", "now", "abc", "hello", signature, now, errSignatureStale},
+	}
+	for i, item := range list {
+		err := verifySignature("secret", item.method, item.path, item.timestamp, item.nonce, []byte(item.body), item.signature, item.now)
+		if err != item.err {
+			log.Printf("case %d: should be %v, got %v", i, item.err, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestResultCacheAdd(t *testing.T) {
+	cache := newResultCache(time.Minute)
+	if !cache.add("nonce", "") || cache.add("nonce", "") {
+		log.Println("a key should only be added once")
+		t.Fail()
+	}
+}
+
+func TestCheckSigned(t *testing.T) {
+	signed := &d.ChannelData{ID: "test", SigningSecret: "secret"}
+	if checkSigned(signed, false) != errSignatureRequired || checkSigned(signed, true) != nil {
+		log.Println("a signed channel should only accept verified requests")
+		t.Fail()
+	}
+	if checkSigned(&d.ChannelData{ID: "test"}, false) != nil {
+		t.Fail()
+	}
+}
+
+func TestUseNonceLocalReplay(t *testing.T) {
+	a := testApp(&config{})
+	a.signatureNonces.add("test/abc", "")
+	// rejected before the db, testApp has no store.
+	if err := a.useNonce(context.Background(), "test", "abc"); err != errSignatureReplayed {
+		log.Println("replay should be rejected: ", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// embedded SMTP server, mail to <channel>+<token>@<domain> is delivered to the channel.
+
+const (
+	smtpMaxMessageSize = 10 << 20
+	smtpMaxRecipients  = 20
+	smtpTimeout        = 5 * time.Minute
+)
+
+var errSMTPAddress = errors.New("recipient should be <channel>+<token>@<domain>")
+
+type smtpRecipient struct {
+	channelID string
+	token     string
+}
+
+// serveSMTP listens on addr and delivers received mail to channels.
+// if domain is not empty, recipients of other domains are rejected.
+func (a *App) serveSMTP(addr, domain string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.logger.Printf("smtp listen Addr: %s\n", addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s := &smtpSession{app: a, domain: domain, conn: conn, text: textproto.NewConn(conn)}
+			s.serve()
+		}()
+	}
+}
+
+type smtpSession struct {
+	app        *App
+	domain     string
+	conn       net.Conn
+	text       *textproto.Conn
+	hello      bool
+	from       string
+	recipients []smtpRecipient
+}
+
+func (s *smtpSession) reply(code int, format string, args ...interface{}) {
+	s.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (s *smtpSession) reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+func (s *smtpSession) serve() {
+	s.conn.SetDeadline(time.Now().Add(smtpTimeout))
+	s.reply(220, "%s ESMTP telegram-messager", s.hostname())
+	for {
+		line, err := s.text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.conn.SetDeadline(time.Now().Add(smtpTimeout))
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			s.hello = true
+			s.reply(250, "%s", s.hostname())
+		case "EHLO":
+			s.hello = true
+			s.text.PrintfLine("250-%s", s.hostname())
+			s.text.PrintfLine("250-SIZE %d", smtpMaxMessageSize)
+			s.text.PrintfLine("250-8BITMIME")
+			s.reply(250, "SMTPUTF8")
+		case "MAIL":
+			if !s.hello {
+				s.reply(503, "send HELO first")
+				continue
+			}
+			from, ok := smtpPathArg(arg, "FROM:")
+			if !ok {
+				s.reply(501, "syntax: MAIL FROM:<address>")
+				continue
+			}
+			s.reset()
+			s.from = from
+			s.reply(250, "OK")
+		case "RCPT":
+			if s.from == "" {
+				s.reply(503, "send MAIL first")
+				continue
+			}
+			to, ok := smtpPathArg(arg, "TO:")
+			if !ok {
+				s.reply(501, "syntax: RCPT TO:<address>")
+				continue
+			}
+			if len(s.recipients) >= smtpMaxRecipients {
+				s.reply(452, "too many recipients")
+				continue
+			}
+			rcpt, err := parseSMTPRecipient(to, s.domain)
+			if err != nil {
+				s.reply(550, "%s", err)
+				continue
+			}
+			s.recipients = append(s.recipients, rcpt)
+			s.reply(250, "OK")
+		case "DATA":
+			if len(s.recipients) == 0 {
+				s.reply(503, "send RCPT first")
+				continue
+			}
+			s.reply(354, "end data with <CR><LF>.<CR><LF>")
+			s.data()
+			s.reset()
+		case "RSET":
+			s.reset()
+			s.reply(250, "OK")
+		case "NOOP":
+			s.reply(250, "OK")
+		case "QUIT":
+			s.reply(221, "bye")
+			return
+		default:
+			s.reply(502, "command not implemented")
+		}
+	}
+}
+
+func (s *smtpSession) data() {
+	dr := s.text.DotReader()
+	raw, err := ioutil.ReadAll(io.LimitReader(dr, smtpMaxMessageSize+1))
+	if err != nil {
+		s.reply(451, "read data failed")
+		return
+	}
+	if len(raw) > smtpMaxMessageSize {
+		// drain the rest of the message, a new dot reader would wait for the next one.
+		io.Copy(ioutil.Discard, dr)
+		s.reply(552, "message too large")
+		return
+	}
+	msg, err := parseMail(raw)
+	if err != nil {
+		s.app.logger.Println("smtp parse mail failed: ", err)
+		s.reply(554, "parse mail failed")
+		return
+	}
+	s.app.logger.Printf("smtp mail from %s to %d channel", s.from, len(s.recipients))
+
+	failed := make([]string, 0)
+	limited := 0
+	for _, rcpt := range s.recipients {
+		_, err := s.app.sendToChannel(context.Background(), sendAuth{ChannelID: rcpt.channelID, Token: rcpt.token, ClientIP: addrIP(s.conn.RemoteAddr())}, msg)
+		if err != nil {
+			s.app.logger.Printf("smtp deliver to %s failed: %s", rcpt.channelID, err)
+			failed = append(failed, rcpt.channelID+": "+err.Error())
+			var limitErr *rateLimitError
+			if errors.As(err, &limitErr) {
+				limited++
+			}
+		}
+	}
+	// nothing delivered and rate limited, the sender should retry later.
+	if limited > 0 && limited == len(s.recipients) {
+		s.reply(451, "rate limited, try again later")
+		return
+	}
+	if len(failed) > 0 {
+		s.reply(554, "delivery failed, %s", strings.Join(failed, "; "))
+		return
+	}
+	s.reply(250, "OK, delivered")
+}
+
+func (s *smtpSession) hostname() string {
+	if s.domain != "" {
+		return s.domain
+	}
+	return "localhost"
+}
+
+// smtpPathArg parses "FROM:<address> PARAMS" style arguments.
+func smtpPathArg(arg, prefix string) (string, bool) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.Index(arg, ">")
+	if end < 0 {
+		return "", false
+	}
+	// null sender <> is allowed for MAIL FROM, use a placeholder.
+	if end == 1 {
+		return "<>", true
+	}
+	return arg[1:end], true
+}
+
+// parseSMTPRecipient parses <channel>+<token>@<domain>.
+func parseSMTPRecipient(address, domain string) (smtpRecipient, error) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return smtpRecipient{}, errSMTPAddress
+	}
+	if domain != "" && !strings.EqualFold(address[at+1:], domain) {
+		return smtpRecipient{}, errors.New("relay not permitted")
+	}
+	channelID, token, ok := strings.Cut(address[:at], "+")
+	if !ok || !checkChannelName(channelID) || token == "" {
+		return smtpRecipient{}, errSMTPAddress
+	}
+	return smtpRecipient{channelID: channelID, token: token}, nil
+}
+
+// parseMail converts a raw mail to a channel message.
+// the subject and the plain text body become the text, attachments are kept as files.
+func parseMail(raw []byte) (*channelMessage, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+
+	result := &channelMessage{}
+	body, err := readMailPart(textproto.MIMEHeader(m.Header), m.Body, result)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(subject)
+	if body = strings.TrimSpace(body); body != "" {
+		if text != "" {
+			text += "\n\n"
+		}
+		text += body
+	}
+	result.Text = text
+	return result, nil
+}
+
+// readMailPart walks the mime tree, returns the first text/plain body and collects attachments into msg.
+func readMailPart(header textproto.MIMEHeader, body io.Reader, msg *channelMessage) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	body = decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		text := ""
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return text, err
+			}
+			partText, err := readMailPart(part.Header, part, msg)
+			if err != nil {
+				return text, err
+			}
+			if text == "" {
+				text = partText
+			}
+		}
+		return text, nil
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if disposition == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/") {
+		if filename == "" {
+			filename = "attachment"
+		}
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+			filename = decoded
+		}
+		msg.Attachments = append(msg.Attachments, attachment{Name: filename, Data: data})
+		return "", nil
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
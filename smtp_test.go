@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSMTPRecipient(t *testing.T) {
+	rcpt, err := parseSMTPRecipient("nas_alert+abcDEF@example.com", "example.com")
+	if err != nil || rcpt.channelID != "nas_alert" || rcpt.token != "abcDEF" {
+		log.Println(rcpt, err)
+		t.Fail()
+	}
+	for _, address := range []string{"nas@example.com", "nas+@example.com", "n a+x@example.com", "nas+abc@other.com", "nas+abc"} {
+		if _, err := parseSMTPRecipient(address, "example.com"); err == nil {
+			log.Printf("[%s] should fail\n", address)
+			t.Fail()
+		}
+	}
+	if _, err := parseSMTPRecipient("nas+abc@other.com", ""); err != nil {
+		t.Fail()
+	}
+}
+
+func TestSMTPPathArg(t *testing.T) {
+	if addr, ok := smtpPathArg("FROM:<ups@local> SIZE=100", "FROM:"); !ok || addr != "ups@local" {
+		t.Fail()
+	}
+	if addr, ok := smtpPathArg("to: <a+b@c>", "TO:"); !ok || addr != "a+b@c" {
+		t.Fail()
+	}
+	if _, ok := smtpPathArg("TO:a@b", "TO:"); ok {
+		t.Fail()
+	}
+}
+
+func TestParseMail(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: nas@local",
+		"To: nas+token@example.com",
+		"Subject: =?UTF-8?B?5aSH5Lu95a6M5oiQ?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="b1"`,
+		"",
+		"--b1",
+		`Content-Type: multipart/alternative; boundary="b2"`,
+		"",
+		"--b2",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"backup =3D ok",
+		"--b2",
+		"Content-Type: text/html",
+		"",
+		"<p>backup = ok</p>",
+		"--b2--",
+		"--b1",
+		`Content-Type: text/plain; name="report.txt"`,
+		"Content-Disposition: attachment; filename=\"report.txt\"",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"aGVsbG8g",
+		"d29ybGQ=",
+		"--b1--",
+		"",
+	}, "\r\n")
+
+	msg, err := parseMail([]byte(raw))
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	if msg.Text != "备份完成\n\nbackup = ok" {
+		log.Printf("unexpected text: %q\n", msg.Text)
+		t.Fail()
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Name != "report.txt" || string(msg.Attachments[0].Data) != "hello world" {
+		log.Printf("unexpected attachments: %v\n", msg.Attachments)
+		t.Fail()
+	}
+}
+
+func TestSMTPMessageTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		s := &smtpSession{app: testApp(&config{}), conn: server, text: textproto.NewConn(server)}
+		s.serve()
+	}()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	c := textproto.NewConn(client)
+	expect := func(code int) {
+		if _, _, err := c.ReadResponse(code); err != nil {
+			log.Println("unexpected reply: ", err)
+			t.FailNow()
+		}
+	}
+	expect(220)
+	for _, command := range []string{"HELO test", "MAIL FROM:<a@b>", "RCPT TO:<ch+token@example.com>"} {
+		c.PrintfLine("%s", command)
+		expect(250)
+	}
+	c.PrintfLine("DATA")
+	expect(354)
+	// pipe writes block until read, the session reads while we write.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := c.DotWriter()
+		line := append(bytes.Repeat([]byte("x"), 1000), '\n')
+		for i := 0; i <= smtpMaxMessageSize/len(line); i++ {
+			w.Write(line)
+		}
+		w.Close()
+	}()
+	expect(552)
+	// the writer flushes the end of the data in Close, wait before using the conn.
+	<-done
+	c.PrintfLine("QUIT")
+	expect(221)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// /start is sent by telegram clients when a user opens the bot, t.me/<bot>?start=<payload> passes the payload.
+// follow_<channel>_<invite> follows the channel with an invite code of the owner.
+
+const followPayloadPrefix = "follow_"
+
+// parseFollowPayload returns the channel and invite code of a follow payload.
+// channel names may contain _, the invite code is after the last one.
+func parseFollowPayload(payload string) (channelID, code string, ok bool) {
+	if !strings.HasPrefix(payload, followPayloadPrefix) {
+		return "", "", false
+	}
+	payload = strings.TrimPrefix(payload, followPayloadPrefix)
+	i := strings.LastIndex(payload, "_")
+	if i < 0 || i == len(payload)-1 || !checkChannelName(payload[:i]) {
+		return "", "", false
+	}
+	return payload[:i], payload[i+1:], true
+}
+
+func (a *App) botCommandStart(message *tgbotapi.Message, args string) *OutgoingMessage {
+	payload := strings.TrimSpace(args)
+	if payload == "" {
+		return buildBotResponse(message, "Hi, I forward the messages of channels you follow.\n\n"+a.helpText(a.roleOf(message.Chat.ID)))
+	}
+	a.logger.Printf("start: User: %d payload: %s", message.Chat.ID, payload)
+
+	channelID, code, ok := parseFollowPayload(payload)
+	if !ok {
+		return buildBotResponse(message, "unknown start link, send /help to list the commands")
+	}
+	// a single use invite is consumed together with the follow.
+	switch err := a.store.FollowWithInvite(channelID, message.Chat.ID, code, time.Now()); err {
+	case nil:
+		return buildBotResponse(message, "followed "+channelID)
+	case d.ErrInvalidInvite:
+		return buildBotResponse(message, "invite link invalid or expired, ask the channel owner for a new one")
+	case d.ErrFollowOwner, d.ErrFollowed:
+		return buildBotResponse(message, err.Error())
+	default:
+		a.logger.Println("follow channel failed: ", err)
+		return buildBotResponse(message, "update failed")
+	}
+}
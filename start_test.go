@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"testing"
+)
+
+func TestParseFollowPayload(t *testing.T) {
+	type item struct {
+		payload, channel, code string
+		ok                     bool
+	}
+	list := []item{
+		{"follow_alerts_abc123", "alerts", "abc123", true},
+		{"follow_prod_alerts_abc123", "prod_alerts", "abc123", true},
+		{"follow_alerts", "", "", false},
+		{"follow_alerts_", "", "", false},
+		{"follow_a_abc", "", "", false},
+		{"follow__abc", "", "", false},
+		{"subscribe_alerts_abc123", "", "", false},
+		{"", "", "", false},
+	}
+	for _, i := range list {
+		channel, code, ok := parseFollowPayload(i.payload)
+		if channel != i.channel || code != i.code || ok != i.ok {
+			log.Printf("[%s] should %s %s %t, got %s %s %t\n", i.payload, i.channel, i.code, i.ok, channel, code, ok)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog receiver (RFC 5424 and RFC 3164 over UDP and TCP),
+// lines matching a rule are grouped and pushed to the rule's channel.
+
+const (
+	syslogDefaultGroupWindow = 10 * time.Second
+	syslogDefaultRateLimit   = 6
+	syslogMaxGroupLines      = 20
+	syslogMaxMessageSize     = 64 << 10
+)
+
+var (
+	syslogSeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	syslogFacilityNames = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	rfc3164Time = regexp.MustCompile(`^[A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2} `)
+)
+
+type syslogMessage struct {
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	Message  string
+}
+
+// line is the text pushed to telegram for the message.
+func (m syslogMessage) line() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "[%s] %s", syslogSeverityNames[m.Severity], m.Hostname)
+	if m.AppName != "" {
+		s.WriteString(" " + m.AppName)
+	}
+	s.WriteString(": " + m.Message)
+	return s.String()
+}
+
+// syslogRule routes matching messages to a channel, empty conditions match everything.
+type syslogRule struct {
+	Channel  string   `json:"channel"`
+	Token    string   `json:"token"`
+	Facility []string `json:"facility"`
+	// messages with a severity higher than this are ignored, e.g. "err" matches emerg to err.
+	Severity string `json:"severity"`
+	Hostname string `json:"hostname"`
+	Match    string `json:"match"`
+	// seconds to collect lines before sending them as one message.
+	GroupWindow int `json:"group_window"`
+	// max telegram messages per minute, extra lines are dropped and counted.
+	RateLimit int `json:"rate_limit"`
+
+	facilities  map[int]bool
+	maxSeverity int
+	hostname    *regexp.Regexp
+	match       *regexp.Regexp
+}
+
+func (r *syslogRule) compile() error {
+	if r.Channel == "" || r.Token == "" {
+		return errors.New("channel and token required")
+	}
+	r.facilities = make(map[int]bool)
+	for _, name := range r.Facility {
+		f := indexOf(syslogFacilityNames, name)
+		if f < 0 {
+			return fmt.Errorf("unknown facility %s", name)
+		}
+		r.facilities[f] = true
+	}
+	r.maxSeverity = len(syslogSeverityNames) - 1
+	if r.Severity != "" {
+		if r.maxSeverity = indexOf(syslogSeverityNames, r.Severity); r.maxSeverity < 0 {
+			return fmt.Errorf("unknown severity %s", r.Severity)
+		}
+	}
+	var err error
+	if r.Hostname != "" {
+		if r.hostname, err = regexp.Compile(r.Hostname); err != nil {
+			return err
+		}
+	}
+	if r.Match != "" {
+		if r.match, err = regexp.Compile(r.Match); err != nil {
+			return err
+		}
+	}
+	if r.GroupWindow <= 0 {
+		r.GroupWindow = int(syslogDefaultGroupWindow / time.Second)
+	}
+	if r.RateLimit <= 0 {
+		r.RateLimit = syslogDefaultRateLimit
+	}
+	return nil
+}
+
+func (r *syslogRule) matches(m syslogMessage) bool {
+	if len(r.facilities) > 0 && !r.facilities[m.Facility] {
+		return false
+	}
+	if m.Severity > r.maxSeverity {
+		return false
+	}
+	if r.hostname != nil && !r.hostname.MatchString(m.Hostname) {
+		return false
+	}
+	if r.match != nil && !r.match.MatchString(m.Message) {
+		return false
+	}
+	return true
+}
+
+func loadSyslogRules(path string) ([]*syslogRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*syslogRule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("syslog rule %d: %s", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// syslogGroup collects lines of one rule until the group window ends.
+type syslogGroup struct {
+	lines   []string
+	total   int
+	dropped int
+	// send time of the messages in the last minute, for rate limiting.
+	sent []time.Time
+}
+
+type syslogRouter struct {
+	rules []*syslogRule
+	send  func(rule *syslogRule, text string)
+	// log messages which can't be parsed.
+	debug  bool
+	logger *log.Logger
+	mu     sync.Mutex
+	groups map[*syslogRule]*syslogGroup
+}
+
+func newSyslogRouter(rules []*syslogRule, logger *log.Logger, send func(rule *syslogRule, text string)) *syslogRouter {
+	return &syslogRouter{
+		rules:  rules,
+		send:   send,
+		logger: logger,
+		groups: make(map[*syslogRule]*syslogGroup),
+	}
+}
+
+func (r *syslogRouter) handle(m syslogMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rule := range r.rules {
+		if !rule.matches(m) {
+			continue
+		}
+		g, ok := r.groups[rule]
+		if !ok {
+			g = &syslogGroup{}
+			r.groups[rule] = g
+		}
+		if len(g.lines) == 0 {
+			time.AfterFunc(time.Duration(rule.GroupWindow)*time.Second, func() {
+				r.flush(rule, time.Now())
+			})
+		}
+		g.total++
+		if len(g.lines) < syslogMaxGroupLines {
+			g.lines = append(g.lines, m.line())
+		}
+	}
+}
+
+func (r *syslogRouter) flush(rule *syslogRule, now time.Time) {
+	r.mu.Lock()
+	g := r.groups[rule]
+	if g == nil || len(g.lines) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	recent := g.sent[:0]
+	for _, t := range g.sent {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	g.sent = recent
+	if len(g.sent) >= rule.RateLimit {
+		g.dropped += g.total
+		g.lines, g.total = nil, 0
+		r.mu.Unlock()
+		return
+	}
+	text := syslogGroupText(g.lines, g.total, g.dropped)
+	g.sent = append(g.sent, now)
+	g.lines, g.total, g.dropped = nil, 0, 0
+	r.mu.Unlock()
+
+	r.send(rule, text)
+}
+
+func syslogGroupText(lines []string, total, dropped int) string {
+	var s strings.Builder
+	s.WriteString(strings.Join(lines, "\n"))
+	if more := total - len(lines); more > 0 {
+		fmt.Fprintf(&s, "\n... and %d more", more)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&s, "\n(%d lines dropped by rate limit)", dropped)
+	}
+	return s.String()
+}
+
+// parseSyslog parses RFC 5424 and RFC 3164 messages.
+func parseSyslog(data []byte) (syslogMessage, error) {
+	line := strings.TrimRight(string(data), "\r\n\x00")
+	var m syslogMessage
+	if !strings.HasPrefix(line, "<") {
+		return m, errors.New("missing priority")
+	}
+	end := strings.Index(line, ">")
+	if end < 2 || end > 4 {
+		return m, errors.New("invalid priority")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return m, errors.New("invalid priority")
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	line = line[end+1:]
+
+	if strings.HasPrefix(line, "1 ") {
+		// RFC 5424: VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+		fields := strings.SplitN(line, " ", 7)
+		if len(fields) < 7 {
+			return m, errors.New("invalid rfc5424 message")
+		}
+		m.Hostname = nilValue(fields[2])
+		m.AppName = nilValue(fields[3])
+		m.Message = skipStructuredData(fields[6])
+		m.Message = strings.TrimPrefix(m.Message, "\ufeff")
+		return m, nil
+	}
+
+	// RFC 3164: TIMESTAMP HOSTNAME TAG: MSG
+	if loc := rfc3164Time.FindStringIndex(line); loc != nil {
+		line = line[loc[1]:]
+		host, rest, ok := strings.Cut(line, " ")
+		if ok {
+			m.Hostname, line = host, rest
+		}
+	}
+	if tag, msg, ok := strings.Cut(line, ": "); ok && !strings.Contains(tag, " ") {
+		if i := strings.Index(tag, "["); i > 0 {
+			tag = tag[:i]
+		}
+		m.AppName, line = tag, msg
+	}
+	m.Message = line
+	return m, nil
+}
+
+func nilValue(v string) string {
+	if v == "-" {
+		return ""
+	}
+	return v
+}
+
+// skipStructuredData removes the STRUCTURED-DATA part of a RFC 5424 message.
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "- ") || s == "-" {
+		return strings.TrimPrefix(s[1:], " ")
+	}
+	inParam, escaped := false, false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inParam = !inParam
+		case c == ']' && !inParam:
+			if i+1 >= len(s) || s[i+1] != '[' {
+				return strings.TrimPrefix(s[i+1:], " ")
+			}
+		}
+	}
+	return ""
+}
+
+// serveSyslog listens for syslog messages on udp and tcp addr.
+func (a *App) serveSyslog(addr string, rules []*syslogRule) error {
+	router := newSyslogRouter(rules, a.logger, func(rule *syslogRule, text string) {
+		_, err := a.sendToChannel(context.Background(), sendAuth{ChannelID: rule.Channel, Token: rule.Token}, &channelMessage{Text: text})
+		if err != nil {
+			a.logger.Printf("syslog send to %s failed: %s", rule.Channel, err)
+		}
+	})
+	router.debug = a.cfg.Server.Debug
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.logger.Printf("syslog listen Addr: %s (udp/tcp), %d rules\n", addr, len(rules))
+
+	go func() {
+		buf := make([]byte, syslogMaxMessageSize)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				a.logger.Println("syslog udp read failed: ", err)
+				return
+			}
+			router.receive(buf[:n])
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			readSyslogStream(conn, router.receive)
+		}()
+	}
+}
+
+func (r *syslogRouter) receive(data []byte) {
+	m, err := parseSyslog(data)
+	if err != nil {
+		if r.debug {
+			r.logger.Printf("syslog parse failed: %s %q", err, data)
+		}
+		return
+	}
+	r.handle(m)
+}
+
+// readSyslogStream supports octet counting (RFC 6587) and newline delimited framing.
+func readSyslogStream(conn io.Reader, handle func([]byte)) {
+	r := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		if first[0] >= '1' && first[0] <= '9' {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil || n > syslogMaxMessageSize {
+				return
+			}
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				return
+			}
+			handle(frame)
+			continue
+		}
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			handle(line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if strings.EqualFold(v, value) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	m, err := parseSyslog([]byte(`<34>1 2003-10-11T22:14:15.003Z web1 sshd 123 ID47 [exampleSDID@32473 iut="3" eventSource="A]pp"] Failed password for root` + "\n"))
+	if err != nil || m.Facility != 4 || m.Severity != 2 || m.Hostname != "web1" || m.AppName != "sshd" || m.Message != "Failed password for root" {
+		log.Printf("rfc5424: %#v %v\n", m, err)
+		t.Fail()
+	}
+
+	m, err = parseSyslog([]byte(`<165>1 2003-08-24T05:14:15.000003-07:00 - - - - - disk full`))
+	if err != nil || m.Hostname != "" || m.Message != "disk full" {
+		log.Printf("rfc5424 nil values: %#v %v\n", m, err)
+		t.Fail()
+	}
+
+	m, err = parseSyslog([]byte(`<13>Feb  5 17:32:18 nas kernel[0]: ata1: link down`))
+	if err != nil || m.Facility != 1 || m.Severity != 5 || m.Hostname != "nas" || m.AppName != "kernel" || m.Message != "ata1: link down" {
+		log.Printf("rfc3164: %#v %v\n", m, err)
+		t.Fail()
+	}
+
+	for _, line := range []string{"no priority", "<999>1 x", "<ab>x"} {
+		if _, err := parseSyslog([]byte(line)); err == nil {
+			log.Printf("[%s] should fail\n", line)
+			t.Fail()
+		}
+	}
+}
+
+func TestSyslogRuleMatches(t *testing.T) {
+	rule := &syslogRule{Channel: "ops", Token: "t", Facility: []string{"auth", "authpriv"}, Severity: "err", Hostname: "^web", Match: "(?i)failed"}
+	if err := rule.compile(); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	m := syslogMessage{Facility: 4, Severity: 3, Hostname: "web1", Message: "Failed password"}
+	if !rule.matches(m) {
+		t.Fail()
+	}
+	m.Severity = 4
+	if rule.matches(m) {
+		t.Fail()
+	}
+	m.Severity, m.Hostname = 3, "db1"
+	if rule.matches(m) {
+		t.Fail()
+	}
+
+	if err := (&syslogRule{Channel: "ops", Token: "t", Severity: "bad"}).compile(); err == nil {
+		t.Fail()
+	}
+}
+
+func TestSyslogRouterRateLimit(t *testing.T) {
+	rule := &syslogRule{Channel: "ops", Token: "t", GroupWindow: 3600, RateLimit: 1}
+	rule.compile()
+	sent := make([]string, 0)
+	r := newSyslogRouter([]*syslogRule{rule}, log.Default(), func(rule *syslogRule, text string) {
+		sent = append(sent, text)
+	})
+
+	now := time.Now()
+	for i := 0; i < syslogMaxGroupLines+2; i++ {
+		r.handle(syslogMessage{Severity: 3, Hostname: "web1", Message: "boom"})
+	}
+	r.flush(rule, now)
+	if len(sent) != 1 || !strings.HasSuffix(sent[0], "... and 2 more") {
+		log.Println(sent)
+		t.FailNow()
+	}
+
+	r.handle(syslogMessage{Severity: 3, Hostname: "web1", Message: "boom"})
+	r.flush(rule, now.Add(time.Second))
+	if len(sent) != 1 {
+		t.FailNow()
+	}
+
+	r.handle(syslogMessage{Severity: 3, Hostname: "web1", Message: "boom"})
+	r.flush(rule, now.Add(time.Minute))
+	if len(sent) != 2 || !strings.HasSuffix(sent[1], "(1 lines dropped by rate limit)") {
+		log.Println(sent)
+		t.Fail()
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	d "github.com/hitian/telegram-messager/data"
+)
+
+// bot commands to manage channel tokens.
+
+func (a *App) botCommandToken(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("fetch channel token: User: %d args: %s", message.Chat.ID, args)
+	userID := message.Chat.ID
+	channelName := strings.TrimSpace(args)
+
+	channelInfo, err := a.store.Get(channelName)
+	if err != nil {
+		return buildBotResponse(message, err.Error())
+	}
+	if channelInfo == nil {
+		return buildBotResponse(message, "channel ID not exists")
+	}
+
+	if channelInfo.Owner != userID {
+		return buildBotResponse(message, "only owner can fetch token")
+	}
+
+	if !channelInfo.HasPlaintextToken() {
+		return buildBotResponse(message, "the token is stored hashed and can't be shown again, reset it with /token_rotate "+channelInfo.ID)
+	}
+	// legacy plaintext token, show it the last time and store the hash.
+	token := channelInfo.Token
+	channelInfo.SetToken(token)
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	return buildBotResponse(message, fmt.Sprintf("token: %s\n\n%s", token, tokenShownOnce))
+}
+
+// botCommandMigrateTokens hashes the plaintext tokens of all channels, the tokens keep working.
+func (a *App) botCommandMigrateTokens(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("migrate tokens: User: %d", message.Chat.ID)
+	list, err := a.store.GetAll()
+	if err != nil {
+		a.logger.Println("Error: ", err)
+		return buildBotResponse(message, "fetch list error")
+	}
+	count := 0
+	for i := range list {
+		if !list[i].HasPlaintextToken() {
+			continue
+		}
+		hashed, err := a.store.HashPlaintextToken(list[i].ID)
+		if err != nil {
+			a.logger.Println("update channel info failed ", err)
+			return buildBotResponse(message, fmt.Sprintf("update %s failed, %d migrated", list[i].ID, count))
+		}
+		if hashed {
+			count++
+		}
+	}
+	return buildBotResponse(message, fmt.Sprintf("%d tokens migrated", count))
+}
+
+// recordTokenUse saves the last used time of the token after it was verified,
+// a legacy plaintext channel token is replaced with its hash.
+// only the token fields are written in a transaction, the channel may have changed since it was read.
+func (a *App) recordTokenUse(ch *d.Channel, channelInfo *d.ChannelData, tokenName, token string) {
+	now := time.Now()
+	if tokenName == d.DefaultTokenName && channelInfo.HasPlaintextToken() {
+		if _, err := ch.HashPlaintextToken(channelInfo.ID); err != nil {
+			a.logger.Println("hash plaintext token failed ", err)
+		}
+	}
+	// most sends are within the touch interval and need no write.
+	if !channelInfo.TouchToken(tokenName, now) {
+		return
+	}
+	if err := ch.SaveTokenUse(channelInfo.ID, tokenName, token, now); err != nil {
+		a.logger.Println("update token last used failed ", err)
+	}
+}
+
+func (a *App) botCommandTokenRotate(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("rotate channel token: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) < 1 || len(params) > 2 {
+		return buildBotResponse(message, "wrong params, token_rotate [channel_name] [token_name]")
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	name := d.DefaultTokenName
+	if len(params) == 2 {
+		name = params[1]
+	}
+	token, err := rotateChannelToken(channelInfo, name)
+	if err != nil {
+		return buildBotResponse(message, err.Error())
+	}
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	return buildBotResponse(message, fmt.Sprintf("token %s rotated, the old token stops working now.\n\ntoken: %s\n\n%s", name, token, tokenShownOnce))
+}
+
+func (a *App) botCommandTokenAdd(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("add channel token: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) < 3 || len(params) > 4 {
+		return buildBotResponse(message, "wrong params, token_add [channel_name] [token_name] [send,subscribers] [expiry: 30d, 12h or 2006-01-02]")
+	}
+	name := params[1]
+	scopes := splitTags(params[2])
+	now := time.Now()
+	var expiresAt time.Time
+	var err error
+	if len(params) == 4 {
+		if expiresAt, err = parseExpiry(params[3], now); err != nil {
+			return buildBotResponse(message, err.Error())
+		}
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	token, err := addChannelToken(channelInfo, name, scopes, expiresAt, now)
+	if err != nil {
+		return buildBotResponse(message, err.Error())
+	}
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	return buildBotResponse(message, fmt.Sprintf("token %s added\n\ntoken: %s\n\n%s", name, token, tokenShownOnce))
+}
+
+func (a *App) botCommandTokenList(message *tgbotapi.Message, args string) *OutgoingMessage {
+	channelInfo, errResponse := a.ownedChannel(message, strings.TrimSpace(args))
+	if errResponse != nil {
+		return errResponse
+	}
+
+	var s strings.Builder
+	s.WriteString("channel tokens: \n\n")
+	fmt.Fprintf(&s, " %s [%s] last used: %s\n", d.DefaultTokenName, strings.Join(d.Scopes, ","), formatTime(channelInfo.TokenLastUsed))
+	for _, t := range channelInfo.Tokens {
+		fmt.Fprintf(&s, " %s [%s] last used: %s", t.Name, strings.Join(t.Scopes, ","), formatTime(t.LastUsed))
+		if !t.ExpiresAt.IsZero() {
+			fmt.Fprintf(&s, " expires: %s", formatTime(t.ExpiresAt))
+			if t.Expired(time.Now()) {
+				s.WriteString(" (expired)")
+			}
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString("\n===End===\n")
+	return buildBotResponse(message, s.String())
+}
+
+func (a *App) botCommandTokenRevoke(message *tgbotapi.Message, args string) *OutgoingMessage {
+	a.logger.Printf("revoke channel token: User: %d args: %s", message.Chat.ID, args)
+	params := strings.Fields(args)
+	if len(params) != 2 {
+		return buildBotResponse(message, "wrong params, token_revoke [channel_name] [token_name]")
+	}
+	if params[1] == d.DefaultTokenName {
+		return buildBotResponse(message, "the default token can't be revoked, use token_rotate")
+	}
+
+	channelInfo, errResponse := a.ownedChannel(message, params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	if !channelInfo.RevokeToken(params[1]) {
+		return buildBotResponse(message, "token not found")
+	}
+	if err := a.store.Update(channelInfo); err != nil {
+		a.logger.Println("update channel info failed ", err)
+		return buildBotResponse(message, "update failed")
+	}
+	return buildBotResponse(message, "token "+params[1]+" revoked")
+}
+
+// rotateChannelToken replaces the named token with a new one, the scopes and expiry are kept.
+func rotateChannelToken(channelInfo *d.ChannelData, name string) (string, error) {
+	token := generateToken()
+	if name == d.DefaultTokenName {
+		channelInfo.SetToken(token)
+		channelInfo.TokenLastUsed = time.Time{}
+		return token, nil
+	}
+	old := channelInfo.NamedToken(name)
+	if old == nil {
+		return "", errors.New("token not found")
+	}
+	limit := old.RateLimit
+	channelInfo.AddToken(name, token, old.Scopes, old.ExpiresAt, time.Now())
+	channelInfo.SetTokenRateLimit(name, limit)
+	return token, nil
+}
+
+// addChannelToken adds a new named token to the channel.
+func addChannelToken(channelInfo *d.ChannelData, name string, scopes []string, expiresAt, now time.Time) (string, error) {
+	if !checkChannelName(name) || name == d.DefaultTokenName {
+		return "", errors.New("token name only accept [a-zA-Z0-9_] and can't be default")
+	}
+	// scopes are checked exactly by Authorize, "Send" is stored as "send".
+	known := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		i := indexOf(d.Scopes, scope)
+		if i < 0 {
+			return "", errors.New("unknown scope " + scope + ", valid scopes: " + strings.Join(d.Scopes, ","))
+		}
+		known = append(known, d.Scopes[i])
+	}
+	if len(known) == 0 {
+		return "", errors.New("scopes required: " + strings.Join(d.Scopes, ","))
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return "", errors.New("expiry should be in the future")
+	}
+	if channelInfo.NamedToken(name) != nil {
+		return "", errors.New("token name exists, use token_rotate or token_revoke")
+	}
+	token := generateToken()
+	channelInfo.AddToken(name, token, known, expiresAt, now)
+	return token, nil
+}
+
+// ownedChannel loads the channel of the message sender,
+// the response is not nil when the channel not exists or the sender is not the owner.
+func (a *App) ownedChannel(message *tgbotapi.Message, channelName string) (*d.ChannelData, *OutgoingMessage) {
+	if channelName == "" {
+		return nil, buildBotResponse(message, "channel name cannot empty")
+	}
+	channelInfo, err := a.store.Get(channelName)
+	if err != nil {
+		return nil, buildBotResponse(message, err.Error())
+	}
+	if channelInfo == nil {
+		return nil, buildBotResponse(message, "channel ID not exists")
+	}
+	if channelInfo.Owner != message.Chat.ID {
+		return nil, buildBotResponse(message, "only owner can do this")
+	}
+	return channelInfo, nil
+}
+
+// parseExpiry accepts a duration like 30d or 12h, or a date 2006-01-02.
+func parseExpiry(expiry string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(expiry, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(expiry, "d"))
+		if err == nil && days > 0 {
+			return now.AddDate(0, 0, days), nil
+		}
+	}
+	if d, err := time.ParseDuration(expiry); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse("2006-01-02", expiry); err == nil && t.After(now) {
+		return t, nil
+	}
+	return time.Time{}, errors.New("wrong expiry, use 30d, 12h or 2006-01-02 in the future")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	d "github.com/hitian/telegram-messager/data"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := map[string]time.Time{
+		"30d":        now.AddDate(0, 0, 30),
+		"12h":        now.Add(12 * time.Hour),
+		"2023-02-01": time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for expiry, want := range list {
+		got, err := parseExpiry(expiry, now)
+		if err != nil || !got.Equal(want) {
+			log.Printf("[%s] should be %s, got %s %v", expiry, want, got, err)
+			t.Fail()
+		}
+	}
+	for _, expiry := range []string{"", "0d", "-1h", "2022-01-01", "soon"} {
+		if _, err := parseExpiry(expiry, now); err == nil {
+			log.Printf("[%s] should fail", expiry)
+			t.Fail()
+		}
+	}
+}
+
+func TestAddRotateChannelToken(t *testing.T) {
+	now := time.Now()
+	channelInfo := &d.ChannelData{ID: "test"}
+	channelInfo.SetToken(generateToken())
+
+	for _, name := range []string{d.DefaultTokenName, "a b"} {
+		if _, err := addChannelToken(channelInfo, name, []string{d.ScopeSend}, time.Time{}, now); err == nil {
+			log.Printf("token name [%s] should be rejected", name)
+			t.Fail()
+		}
+	}
+	if _, err := addChannelToken(channelInfo, "ci", []string{"admin"}, time.Time{}, now); err == nil {
+		log.Println("unknown scope should be rejected")
+		t.Fail()
+	}
+	token, err := addChannelToken(channelInfo, "ci", []string{d.ScopeSend}, time.Time{}, now)
+	if err != nil {
+		log.Println("add token failed: ", err)
+		t.FailNow()
+	}
+	if _, err := addChannelToken(channelInfo, "ci", []string{d.ScopeSend}, time.Time{}, now); err == nil {
+		log.Println("duplicated token name should be rejected")
+		t.Fail()
+	}
+	upper, err := addChannelToken(channelInfo, "ci_upper", []string{"Send"}, time.Time{}, now)
+	if _, ok := channelInfo.Authorize(upper, d.ScopeSend, now); err != nil || !ok {
+		log.Println("scope should be stored lowercase: ", err)
+		t.Fail()
+	}
+
+	rotated, err := rotateChannelToken(channelInfo, "ci")
+	if err != nil || rotated == token {
+		log.Println("rotate token failed: ", err)
+		t.FailNow()
+	}
+	if _, ok := channelInfo.Authorize(token, d.ScopeSend, now); ok {
+		log.Println("old token should not work after rotate")
+		t.Fail()
+	}
+	if name, ok := channelInfo.Authorize(rotated, d.ScopeSend, now); !ok || name != "ci" {
+		log.Println("rotated token should work")
+		t.Fail()
+	}
+	if _, ok := channelInfo.Authorize(rotated, d.ScopeSubscribers, now); ok {
+		log.Println("rotated token should keep its scopes")
+		t.Fail()
+	}
+	if _, err := rotateChannelToken(channelInfo, "missing"); err == nil {
+		log.Println("rotate missing token should fail")
+		t.Fail()
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := parseRateLimit([]string{"minute=30", "burst=10", "day=1000"})
+	if err != nil || limit != (d.RateLimit{PerMinute: 30, Burst: 10, Daily: 1000}) {
+		log.Println(limit, err)
+		t.Fail()
+	}
+	if limit, err := parseRateLimit([]string{"off"}); err != nil || !limit.IsZero() {
+		log.Println(limit, err)
+		t.Fail()
+	}
+	for _, fields := range [][]string{{}, {"minute"}, {"minute=-1"}, {"hour=1"}, {"burst=5"}} {
+		if _, err := parseRateLimit(fields); err == nil {
+			log.Printf("%v should fail", fields)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// telegram redelivers updates when the webhook is slow or fails, commands like /new must run once.
+
+const (
+	// telegram keeps undelivered updates for 24 hours.
+	processedUpdateTTL = 24 * time.Hour
+)
+
+// firstDelivery records the update id, it returns false if the update was handled before.
+// the update is handled when the db fails, a lost command is worse than a rare duplicate.
+func (a *App) firstDelivery(updateID int) bool {
+	if !a.processedUpdates.add(strconv.Itoa(updateID), "") {
+		return false
+	}
+	first, err := a.store.MarkUpdate(updateID, processedUpdateTTL, time.Now())
+	if err != nil {
+		a.logger.Printf("mark update %d failed: %s", updateID, err)
+		return true
+	}
+	return first
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// telegram webhook registration and verification.
+
+var (
+	// https://core.telegram.org/bots/webhooks#the-short-version
+	telegramIPRanges = []string{"149.154.160.0/20", "91.108.4.0/22"}
+
+	webhookSecretPattern = regexp.MustCompile("^[A-Za-z0-9_-]{1,256}$")
+)
+
+// defaultWebhookSecret derives the secret from the bot token, so all instances agree without config.
+func defaultWebhookSecret(telegramToken string) string {
+	mac := hmac.New(sha256.New, []byte(telegramToken))
+	mac.Write([]byte("webhook secret"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookConfig is the webhook section of the config.
+type webhookConfig struct {
+	// webhook url is Domain + BotURI.
+	Domain string `yaml:"domain" toml:"domain" env:"DOMAIN"`
+	BotURI string `yaml:"bot_uri" toml:"bot_uri" env:"BOT_URI"`
+	// secret_token of setWebhook, derived from the bot token when empty.
+	Secret     string `yaml:"secret" toml:"secret" env:"WEBHOOK_SECRET"`
+	SecretFile string `yaml:"secret_file" toml:"secret_file" env:"WEBHOOK_SECRET_FILE"`
+	// reject updates from outside telegramIPRanges.
+	CheckIP bool `yaml:"check_ip" toml:"check_ip" env:"WEBHOOK_CHECK_IP"`
+	// auto registers when the webhook differs, always on every start, skip never.
+	Register       string   `yaml:"register" toml:"register" env:"WEBHOOK_REGISTER"`
+	DropPending    bool     `yaml:"drop_pending" toml:"drop_pending" env:"WEBHOOK_DROP_PENDING"`
+	MaxConnections int      `yaml:"max_connections" toml:"max_connections" env:"WEBHOOK_MAX_CONNECTIONS"`
+	AllowedUpdates []string `yaml:"allowed_updates" toml:"allowed_updates" env:"WEBHOOK_ALLOWED_UPDATES"`
+}
+
+// telegramWebhookInfo is the result of getWebhookInfo, the v4 api misses some fields.
+type telegramWebhookInfo struct {
+	URL                string   `json:"url"`
+	PendingUpdateCount int      `json:"pending_update_count"`
+	LastErrorDate      int64    `json:"last_error_date"`
+	LastErrorMessage   string   `json:"last_error_message"`
+	MaxConnections     int      `json:"max_connections"`
+	AllowedUpdates     []string `json:"allowed_updates"`
+}
+
+// needsRegister checks the current webhook against the config.
+// the secret token is not in the webhook info, secretRegistered tells the last registration of this
+// install had the same url and secret, webhooks registered by older versions have no secret.
+func (w *webhookConfig) needsRegister(info *telegramWebhookInfo, webhookURL string, secretRegistered bool) bool {
+	switch w.Register {
+	case "skip":
+		return false
+	case "always":
+		return true
+	}
+	if !secretRegistered || info == nil || info.URL != webhookURL {
+		return true
+	}
+	if w.MaxConnections > 0 && info.MaxConnections != w.MaxConnections {
+		return true
+	}
+	if w.AllowedUpdates != nil && strings.Join(info.AllowedUpdates, ",") != strings.Join(w.AllowedUpdates, ",") {
+		return true
+	}
+	return false
+}
+
+// webhookRegistrationHash identifies the url and secret of a registration, the secret itself is not stored.
+func webhookRegistrationHash(webhookURL, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(webhookURL))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// registerWebhook sets the webhook when needed and serves the updates on BOT_URI.
+// a failed registration is logged and shown on /sysinfo, the webhook set before may still work.
+func (a *App) registerWebhook(router *gin.Engine) {
+	webhookURL := a.cfg.Webhook.Domain + a.cfg.Webhook.BotURI
+	info, infoErr := getWebhookInfo(a.bot)
+	if infoErr != nil {
+		a.logger.Println("get webhook info failed: ", infoErr)
+	}
+	hash := webhookRegistrationHash(webhookURL, a.webhookSecret)
+	registered, err := a.store.WebhookRegistration()
+	if err != nil {
+		// registering again is harmless.
+		a.logger.Println("get webhook registration failed: ", err)
+	}
+	if a.cfg.Webhook.needsRegister(info, webhookURL, registered == hash) {
+		if err := setWebhook(a.bot, webhookURL, a.webhookSecret, &a.cfg.Webhook); err != nil {
+			a.logger.Println("set webhook failed: ", err)
+			a.webhookRegisterError = err.Error()
+		} else {
+			a.logger.Println("webhook registered")
+			if err := a.store.SaveWebhookRegistration(hash, time.Now()); err != nil {
+				a.logger.Println("save webhook registration failed: ", err)
+			}
+		}
+	} else {
+		// the info is current when the webhook isn't registered again, otherwise /sysinfo gets it.
+		a.webhookInfo.set("", a.webhookInfoStatus(info, infoErr))
+	}
+	if info != nil && info.LastErrorDate != 0 {
+		a.logger.Printf("Telegram callback failed: %s", info.LastErrorMessage)
+	}
+
+	router.POST("/"+a.cfg.Webhook.BotURI, func(c *gin.Context) {
+		// forged updates could run commands as any user.
+		if !a.verifyWebhook(c) {
+			c.String(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		bytes, _ := ioutil.ReadAll(c.Request.Body)
+		if a.cfg.Server.Debug {
+			a.logger.Println("callback received:", string(bytes))
+		}
+		var update tgbotapi.Update
+		err := json.Unmarshal(bytes, &update)
+		if err != nil {
+			// telegram would redeliver it forever, a retry can't fix the data.
+			a.logger.Printf("callback data decode failed: %s \n%s", err, string(bytes))
+			c.String(http.StatusOK, "OK")
+			return
+		}
+
+		// lambda is frozen after the response, the update is handled before it.
+		if a.cfg.Server.Lambda {
+			a.processUpdate(update)
+		} else {
+			go a.processUpdate(update)
+		}
+		c.String(http.StatusOK, "OK")
+	})
+}
+
+func getWebhookInfo(bot *tgbotapi.BotAPI) (*telegramWebhookInfo, error) {
+	resp, err := bot.MakeRequest("getWebhookInfo", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	var info telegramWebhookInfo
+	if err := json.Unmarshal(resp.Result, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// setWebhook registers the webhook with the secret token, the v4 api has no secret_token field.
+func setWebhook(bot *tgbotapi.BotAPI, webhookURL, secret string, config *webhookConfig) error {
+	params := url.Values{}
+	params.Set("url", webhookURL)
+	params.Set("secret_token", secret)
+	if config.DropPending {
+		params.Set("drop_pending_updates", "true")
+	}
+	if config.MaxConnections > 0 {
+		params.Set("max_connections", strconv.Itoa(config.MaxConnections))
+	}
+	if config.AllowedUpdates != nil {
+		allowed, _ := json.Marshal(config.AllowedUpdates)
+		params.Set("allowed_updates", string(allowed))
+	}
+	_, err := bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+// webhookSysinfo is the webhook status of /sysinfo, the url is not shown as BOT_URI is a secret.
+func (a *App) webhookSysinfo() string {
+	if a.bot == nil || a.cfg.Telegram.Mode == "polling" {
+		return ""
+	}
+	result := ""
+	if a.webhookRegisterError != "" {
+		result += a.webhookLabel() + " register error: " + a.webhookRegisterError
+	}
+	status, ok := a.webhookInfo.get("")
+	if !ok {
+		status = a.webhookInfoStatus(getWebhookInfo(a.bot))
+		a.webhookInfo.set("", status)
+	}
+	return result + status
+}
+
+// webhookInfoStatus formats the result of getWebhookInfo for /sysinfo.
+func (a *App) webhookInfoStatus(info *telegramWebhookInfo, err error) string {
+	label := a.webhookLabel()
+	if err != nil {
+		return label + " info failed: " + err.Error()
+	}
+	result := fmt.Sprintf("%s URL match: %t%s pending updates: %d", label, info.URL == a.cfg.Webhook.Domain+a.cfg.Webhook.BotURI, label, info.PendingUpdateCount)
+	if info.LastErrorDate != 0 {
+		result += fmt.Sprintf("%s last error: %s %s", label, time.Unix(info.LastErrorDate, 0).UTC().Format(time.RFC3339), info.LastErrorMessage)
+	}
+	return result
+}
+
+func (a *App) webhookLabel() string {
+	if a.name == "" {
+		return "\nWebhook"
+	}
+	return "\nWebhook " + a.name
+}
+
+// webhookSysinfo is the webhook status of all bots.
+func (s *Server) webhookSysinfo() string {
+	result := ""
+	for _, a := range s.apps {
+		result += a.webhookSysinfo()
+	}
+	return result
+}
+
+// verifyWebhook checks the secret token and the source ip of an update request.
+func (a *App) verifyWebhook(c *gin.Context) bool {
+	secret := c.GetHeader("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.webhookSecret)) != 1 {
+		a.logger.Printf("webhook rejected from %s: secret token not match", a.senderIP(c))
+		return false
+	}
+	if a.cfg.Webhook.CheckIP {
+		if ip := a.senderIP(c); !ipAllowed(telegramIPRanges, ip) {
+			a.logger.Printf("webhook rejected from %s: not a telegram ip", ip)
+			return false
+		}
+	}
+	return true
+}
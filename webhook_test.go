@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	a := testApp(&config{Telegram: telegramConfig{Token: "123:abc"}})
+	webhookSecret := a.webhookSecret
+	if webhookSecret != defaultWebhookSecret("123:abc") || !webhookSecretPattern.MatchString(webhookSecret) || webhookSecret == defaultWebhookSecret("123:abd") {
+		log.Println("wrong default secret: ", webhookSecret)
+		t.Fail()
+	}
+
+	router := gin.New()
+	router.POST("/bot", func(c *gin.Context) {
+		if !a.verifyWebhook(c) {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	for _, item := range []struct {
+		secret     string
+		checkIP    bool
+		remoteAddr string
+		want       int
+	}{
+		{webhookSecret, false, "1.2.3.4:1234", http.StatusOK},
+		{"", false, "1.2.3.4:1234", http.StatusUnauthorized},
+		{"wrong", false, "149.154.167.1:1234", http.StatusUnauthorized},
+		{webhookSecret, true, "149.154.167.1:1234", http.StatusOK},
+		{webhookSecret, true, "1.2.3.4:1234", http.StatusUnauthorized},
+	} {
+		a.cfg.Webhook.CheckIP = item.checkIP
+		req := httptest.NewRequest(http.MethodPost, "/bot", nil)
+		req.RemoteAddr = item.remoteAddr
+		if item.secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", item.secret)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != item.want {
+			log.Printf("secret %s ip %s check %t: should be %d, got %d", item.secret, item.remoteAddr, item.checkIP, item.want, w.Code)
+			t.Fail()
+		}
+	}
+}
+
+func TestWebhookNeedsRegister(t *testing.T) {
+	url := "https://example.com/bot"
+	info := &telegramWebhookInfo{URL: url, MaxConnections: 40, AllowedUpdates: []string{"message"}}
+	list := []struct {
+		config webhookConfig
+		info   *telegramWebhookInfo
+		want   bool
+		// registered by an older version without the secret.
+		noSecret bool
+	}{
+		{webhookConfig{Register: "auto"}, info, false, false},
+		{webhookConfig{Register: "auto"}, nil, true, false},
+		{webhookConfig{Register: "auto"}, &telegramWebhookInfo{URL: "https://example.com/old"}, true, false},
+		{webhookConfig{Register: "auto", MaxConnections: 40, AllowedUpdates: []string{"message"}}, info, false, false},
+		{webhookConfig{Register: "auto", MaxConnections: 10}, info, true, false},
+		{webhookConfig{Register: "auto", AllowedUpdates: []string{"message", "callback_query"}}, info, true, false},
+		{webhookConfig{Register: "always"}, info, true, false},
+		{webhookConfig{Register: "skip"}, nil, false, false},
+		{webhookConfig{Register: "auto"}, info, true, true},
+		{webhookConfig{Register: "skip"}, info, false, true},
+	}
+	for i, item := range list {
+		if item.config.needsRegister(item.info, url, !item.noSecret) != item.want {
+			log.Printf("case %d: should be %t", i, item.want)
+			t.Fail()
+		}
+	}
+}
+
+func TestWebhookSysinfoCached(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"test","username":"test_bot"}}`))
+		case "/bot123:abc/getWebhookInfo":
+			calls++
+			w.Write([]byte(`{"ok":true,"result":{"url":"https://example.com/bot","pending_update_count":3}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a := testApp(&config{Telegram: telegramConfig{Token: "123:abc"}, Webhook: webhookConfig{Domain: "https://example.com/", BotURI: "bot"}})
+	bot, err := newBotAPI("123:abc", server.URL+"/")
+	if err != nil {
+		log.Println("create bot failed: ", err)
+		t.FailNow()
+	}
+	a.bot = bot
+	for i := 0; i < 3; i++ {
+		if result := a.webhookSysinfo(); result != "\nWebhook URL match: true\nWebhook pending updates: 3" {
+			log.Println("wrong sysinfo: ", result)
+			t.Fail()
+		}
+	}
+	if calls != 1 {
+		log.Printf("getWebhookInfo should be called once, got %d", calls)
+		t.Fail()
+	}
+}